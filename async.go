@@ -0,0 +1,131 @@
+package retraced
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// EventReporter reports events. It is implemented by Client and AsyncReporter.
+type EventReporter interface {
+	ReportEvent(event *Event) (*NewEventRecord, error)
+}
+
+// reportEventContext reports event with ctx if reporter supports it, like
+// Client and Router do.
+func reportEventContext(ctx context.Context, reporter EventReporter, event *Event) (*NewEventRecord, error) {
+	if r, ok := reporter.(interface {
+		ReportEventContext(context.Context, *Event) (*NewEventRecord, error)
+	}); ok {
+		return r.ReportEventContext(ctx, event)
+	}
+	return reporter.ReportEvent(event)
+}
+
+// ErrQueueFull is returned by AsyncReporter.ReportEvent when its queue is full.
+var ErrQueueFull = errors.New("retraced: async reporter queue is full")
+
+// ErrReporterClosed is returned by AsyncReporter.ReportEvent after Close.
+var ErrReporterClosed = errors.New("retraced: async reporter is closed")
+
+// AsyncReporter queues events and reports them from background workers, so
+// callers don't wait on the Retraced API.
+type AsyncReporter struct {
+	// OnError is called from a worker when an event fails to report. The
+	// default logs the error.
+	OnError func(event *Event, err error)
+	// Logger receives errors when OnError is not set, default is the Logger of
+	// the reporter if it is a Client, else slog.Default()
+	Logger Logger
+	// Metrics receives the queue depth and outcome of queued events, default is
+	// the Metrics of the reporter if it is a Client
+	Metrics Metrics
+
+	reporter EventReporter
+	queue    chan *Event
+	closed   bool
+	mtx      sync.RWMutex
+	wg       sync.WaitGroup
+}
+
+// NewAsyncReporter starts workers that report queued events through reporter.
+func NewAsyncReporter(reporter EventReporter, queueSize int, workers int) *AsyncReporter {
+	if workers < 1 {
+		workers = 1
+	}
+	a := &AsyncReporter{
+		reporter: reporter,
+		queue:    make(chan *Event, queueSize),
+	}
+	a.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go a.work()
+	}
+	return a
+}
+
+func (a *AsyncReporter) work() {
+	defer a.wg.Done()
+	for event := range a.queue {
+		metrics := a.metrics()
+		metrics.SetGauge(MetricAsyncQueueDepth, float64(len(a.queue)))
+		if _, err := a.reporter.ReportEvent(event); err != nil {
+			metrics.IncCounter(MetricAsyncReport, MetricStatusError)
+			if a.OnError != nil {
+				a.OnError(event, err)
+			} else {
+				logger := a.Logger
+				if logger == nil {
+					logger = reporterLogger(a.reporter)
+				}
+				logger.ErrorContext(context.Background(), "retraced: failed to report event", "action", event.Action, "error", err)
+			}
+			continue
+		}
+		metrics.IncCounter(MetricAsyncReport, MetricStatusOK)
+	}
+}
+
+// ReportEvent queues the event without blocking. The returned record is always
+// nil, because the event has not been reported yet.
+func (a *AsyncReporter) ReportEvent(event *Event) (*NewEventRecord, error) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	if a.closed {
+		return nil, ErrReporterClosed
+	}
+	select {
+	case a.queue <- event:
+		a.metrics().SetGauge(MetricAsyncQueueDepth, float64(len(a.queue)))
+		return nil, nil
+	default:
+		a.metrics().IncCounter(MetricAsyncReport, MetricStatusDropped)
+		return nil, ErrQueueFull
+	}
+}
+
+func (a *AsyncReporter) metrics() Metrics {
+	if a.Metrics != nil {
+		return a.Metrics
+	}
+	if c, ok := a.reporter.(*Client); ok {
+		return c.metrics()
+	}
+	return NopMetrics{}
+}
+
+// QueueDepth returns the number of events waiting to be reported.
+func (a *AsyncReporter) QueueDepth() int {
+	return len(a.queue)
+}
+
+// Close stops accepting events and waits for queued events to be reported.
+func (a *AsyncReporter) Close() {
+	a.mtx.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mtx.Unlock()
+	a.wg.Wait()
+}
@@ -0,0 +1,261 @@
+package retraced
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the Retraced API while a
+// CircuitBreaker is open.
+var ErrCircuitOpen = errors.New("retraced: circuit breaker is open")
+
+// MetricCircuitBreaker counts requests rejected by an open circuit breaker.
+// Use CircuitBreaker.OnStateChange to observe transitions.
+const MetricCircuitBreaker = "circuit_breaker"
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests until the cool-down has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type requestOutcome int
+
+const (
+	outcomeSuccess requestOutcome = iota
+	outcomeFailure
+	// outcomeIgnored releases a probe slot without counting, e.g. when the
+	// caller's context was canceled.
+	outcomeIgnored
+)
+
+// CircuitBreaker stops a Client from sending requests to a degraded Retraced
+// API. It keeps a separate circuit for each endpoint, so a Client with
+// several Endpoints can fail over while one of them is open. Transport errors
+// and 5xx responses count as failures. When the share of failed requests
+// within Window reaches FailureRatio, the circuit opens and requests fail
+// fast with ErrCircuitOpen. After CoolDown, the circuit is half-open and lets
+// HalfOpenProbes requests through; if they all succeed it closes, otherwise
+// it opens again.
+// It is safe for concurrent access.
+type CircuitBreaker struct {
+	// FailureRatio opens the circuit, default is 0.5
+	FailureRatio float64
+	// MinRequests within Window before the circuit can open, default is 10
+	MinRequests int
+	// Window is the period over which failures are counted, default is one minute
+	Window time.Duration
+	// CoolDown is how long the circuit stays open, default is 30 seconds
+	CoolDown time.Duration
+	// HalfOpenProbes is the number of requests let through while half-open, default is 1
+	HalfOpenProbes int
+	// Fallback, when set, receives events ReportEvent could not send because
+	// the circuit of the last endpoint it tried was open, e.g. a spool. Events are passed
+	// as they would have been sent, after enrichment, redaction, signing and
+	// encryption, so Fallback should not be a Client that processes them
+	// again.
+	Fallback EventReporter
+	// OnStateChange, when set, is called after every state transition of the
+	// circuit of an endpoint, without the CircuitBreaker locked
+	OnStateChange func(endpoint string, from, to CircuitState)
+
+	circuits map[string]*circuit
+	mtx      sync.Mutex
+}
+
+// circuit is the state of a CircuitBreaker for one endpoint.
+type circuit struct {
+	state       CircuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+	generation  uint64
+}
+
+// State returns the current state of the circuit of endpoint.
+func (b *CircuitBreaker) State(endpoint string) CircuitState {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.coolDown() {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// circuit returns the circuit of endpoint. It must be called with b.mtx held.
+func (b *CircuitBreaker) circuit(endpoint string) *circuit {
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		if b.circuits == nil {
+			b.circuits = map[string]*circuit{}
+		}
+		c = &circuit{}
+		b.circuits[endpoint] = c
+	}
+	return c
+}
+
+// allow returns ErrCircuitOpen if a request may not be sent to endpoint now.
+// Every nil error must be followed by a call to record with the returned
+// generation.
+func (b *CircuitBreaker) allow(endpoint string, now time.Time) (uint64, error) {
+	var changes []stateChange
+	defer b.notify(&changes)
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	c := b.circuit(endpoint)
+	switch c.state {
+	case CircuitOpen:
+		if now.Sub(c.openedAt) < b.coolDown() {
+			return 0, ErrCircuitOpen
+		}
+		changes = append(changes, b.transition(endpoint, c, CircuitHalfOpen, now))
+		fallthrough
+	case CircuitHalfOpen:
+		if c.probes >= b.halfOpenProbes() {
+			return 0, ErrCircuitOpen
+		}
+		c.probes++
+	}
+	return c.generation, nil
+}
+
+// record counts the outcome of a request to endpoint allowed in generation.
+// Outcomes of requests allowed before the last transition are ignored, so
+// that they don't release probes or decide the state they were not sent in.
+func (b *CircuitBreaker) record(endpoint string, generation uint64, outcome requestOutcome, now time.Time) {
+	var changes []stateChange
+	defer b.notify(&changes)
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	c := b.circuit(endpoint)
+	if generation != c.generation {
+		return
+	}
+	switch c.state {
+	case CircuitHalfOpen:
+		c.probes--
+		switch outcome {
+		case outcomeFailure:
+			changes = append(changes, b.transition(endpoint, c, CircuitOpen, now))
+		case outcomeSuccess:
+			c.successes++
+			if c.successes >= b.halfOpenProbes() {
+				changes = append(changes, b.transition(endpoint, c, CircuitClosed, now))
+			}
+		}
+	case CircuitClosed:
+		if outcome == outcomeIgnored {
+			return
+		}
+		if now.Sub(c.windowStart) >= b.window() {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+		c.requests++
+		if outcome == outcomeFailure {
+			c.failures++
+		}
+		if c.requests >= b.minRequests() && float64(c.failures)/float64(c.requests) >= b.failureRatio() {
+			changes = append(changes, b.transition(endpoint, c, CircuitOpen, now))
+		}
+	}
+}
+
+// stateChange is a transition of the circuit of endpoint.
+type stateChange struct {
+	endpoint string
+	from, to CircuitState
+}
+
+// transition moves c to the state to. It must be called with b.mtx held, and
+// the returned change passed to notify after b.mtx is released, so that
+// OnStateChange may call State.
+func (b *CircuitBreaker) transition(endpoint string, c *circuit, to CircuitState, now time.Time) stateChange {
+	from := c.state
+	c.state = to
+	c.generation++
+	c.probes, c.successes = 0, 0
+	switch to {
+	case CircuitOpen:
+		c.openedAt = now
+	case CircuitClosed:
+		c.windowStart, c.requests, c.failures = now, 0, 0
+	}
+	return stateChange{endpoint: endpoint, from: from, to: to}
+}
+
+// notify calls OnStateChange for changes. It must be called without b.mtx
+// held.
+func (b *CircuitBreaker) notify(changes *[]stateChange) {
+	if b.OnStateChange == nil {
+		return
+	}
+	for _, change := range *changes {
+		if change.from != change.to {
+			b.OnStateChange(change.endpoint, change.from, change.to)
+		}
+	}
+}
+
+func (b *CircuitBreaker) failureRatio() float64 {
+	if b.FailureRatio <= 0 {
+		return 0.5
+	}
+	return b.FailureRatio
+}
+
+func (b *CircuitBreaker) minRequests() int {
+	if b.MinRequests <= 0 {
+		return 10
+	}
+	return b.MinRequests
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window <= 0 {
+		return time.Minute
+	}
+	return b.Window
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown <= 0 {
+		return 30 * time.Second
+	}
+	return b.CoolDown
+}
+
+func (b *CircuitBreaker) halfOpenProbes() int {
+	if b.HalfOpenProbes <= 0 {
+		return 1
+	}
+	return b.HalfOpenProbes
+}
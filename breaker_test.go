@@ -0,0 +1,102 @@
+package retraced
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	var transitions []CircuitState
+	b := &CircuitBreaker{
+		MinRequests: 4,
+		CoolDown:    time.Second,
+		OnStateChange: func(endpoint string, from, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	}
+	now := time.Now()
+
+	// A request allowed while closed that completes after the circuit opened.
+	stale, err := b.allow("east", now)
+	require.NoError(t, err)
+
+	for _, outcome := range []requestOutcome{outcomeSuccess, outcomeFailure, outcomeSuccess, outcomeFailure} {
+		generation, err := b.allow("east", now)
+		require.NoError(t, err)
+		b.record("east", generation, outcome, now)
+	}
+	assert.Equal(t, CircuitOpen, b.circuits["east"].state)
+	_, err = b.allow("east", now.Add(time.Millisecond))
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	now = now.Add(time.Second)
+	probe, err := b.allow("east", now)
+	require.NoError(t, err, "probe after cool-down")
+	_, err = b.allow("east", now)
+	assert.Equal(t, ErrCircuitOpen, err, "only one probe while half-open")
+	b.record("east", stale, outcomeSuccess, now)
+	assert.Equal(t, CircuitHalfOpen, b.circuits["east"].state, "stale outcome must not close the circuit")
+	assert.Equal(t, 1, b.circuits["east"].probes)
+	b.record("east", probe, outcomeFailure, now)
+	assert.Equal(t, CircuitOpen, b.circuits["east"].state)
+
+	now = now.Add(time.Second)
+	probe, err = b.allow("east", now)
+	require.NoError(t, err)
+	b.record("east", probe, outcomeSuccess, now)
+	assert.Equal(t, CircuitClosed, b.circuits["east"].state)
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, transitions)
+}
+
+func TestCircuitBreakerOnStateChangeCallsState(t *testing.T) {
+	var states []CircuitState
+	b := &CircuitBreaker{MinRequests: 1, CoolDown: time.Second}
+	b.OnStateChange = func(endpoint string, from, to CircuitState) {
+		states = append(states, b.State(endpoint))
+	}
+	now := time.Now()
+
+	generation, err := b.allow("east", now)
+	require.NoError(t, err)
+	b.record("east", generation, outcomeFailure, now)
+	generation, err = b.allow("east", now.Add(time.Second))
+	require.NoError(t, err)
+	b.record("east", generation, outcomeSuccess, now.Add(time.Second))
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}, states)
+}
+
+func TestReportEventCircuitOpenFallback(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	fallback := &recordingReporter{}
+	client, err := NewClient(server.URL, "dev", "dev")
+	require.NoError(t, err)
+	client.CircuitBreaker = &CircuitBreaker{MinRequests: 1, CoolDown: time.Hour}
+
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrCircuitOpen, err)
+
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 1, calls)
+
+	client.CircuitBreaker.Fallback = fallback
+	_, err = client.ReportEvent(&Event{Action: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	require.Len(t, fallback.events, 1)
+	assert.Equal(t, "b", fallback.events[0].Action)
+}
@@ -2,12 +2,16 @@ package retraced
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 const (
@@ -15,12 +19,36 @@ const (
 	defaultEndpoint = "http://localhost:3000/auditlog"
 )
 
+// HashVerificationPolicy controls how ReportEvent handles a reported event
+// whose hash does not match the hash returned by the Retraced API.
+type HashVerificationPolicy int
+
+const (
+	// HashVerificationStrict returns a *HashMismatchError from ReportEvent.
+	HashVerificationStrict HashVerificationPolicy = iota
+	// HashVerificationWarn logs the mismatch and returns the record.
+	HashVerificationWarn
+	// HashVerificationOff skips hash verification.
+	HashVerificationOff
+)
+
 // Client represents a client that can send events into the retraced service.
 type Client struct {
 	projectID string
 	token     string
-	// Endpoint is the retraced api base url, default is `https://api.retraced.io`
+	// Endpoint is the retraced api base url, default is `http://localhost:3000/auditlog`
 	Endpoint string
+	// Endpoints, when set, replaces Endpoint with an ordered list of base urls.
+	// ReportEvent fails over to the next endpoint when one refuses the
+	// connection, responds 429 or 503, or its circuit is open, and queries read
+	// from the healthiest endpoint. Other errors are returned, since the event
+	// may have been stored.
+	Endpoints []string
+	// EndpointCoolDown is how long a failed endpoint is deprioritized, default is 30 seconds
+	EndpointCoolDown time.Duration
+	// DualWrite publishes every event to all Endpoints, see
+	// NewEventRecord.Records and DualWriteError
+	DualWrite bool
 	// Component is an identifier for a specific component of a vendor app platform
 	Component string
 	// Version is an identifier for the specific version of this component, usually a git SHA
@@ -29,49 +57,163 @@ type Client struct {
 	ViewLogAction string
 	//
 	HttpClient *http.Client
+	// Ledger, when set, records a Receipt for every event accepted by ReportEvent
+	Ledger *ReceiptLedger
+	// Enrichers add information to every event before it is reported
+	Enrichers []Enricher
+	// Pseudonymizer, when set, replaces actor and target identifiers with
+	// pseudonyms before events are reported
+	Pseudonymizer *Pseudonymizer
+	// Redactor, when set, scrubs sensitive data from every event before it is reported
+	Redactor *Redactor
+	// Signer, when set, signs every event before it is reported. Signatures
+	// cover plaintext values, before any Encryptor runs.
+	Signer *EventSigner
+	// Encryptor, when set, encrypts selected Fields and Metadata before events
+	// are reported, and decrypts them in Query and Stream results
+	Encryptor *FieldEncryptor
+	// HashFormat selects the hash target format used to verify reported events
+	HashFormat HashFormat
+	// TokenProvider, when set, supplies the API token of every request instead
+	// of the token the client was created with
+	TokenProvider TokenProvider
+	// HashVerification controls how hash mismatches are reported, default is strict
+	HashVerification HashVerificationPolicy
+	// Logger receives the SDK's diagnostics, default is slog.Default()
+	Logger Logger
+	// Metrics collects counters and latencies of API operations, default is NopMetrics
+	Metrics Metrics
+	// Tracer, when set, is called around API operations
+	Tracer Tracer
+	// RateLimiter, when set, limits the rate of ReportEvent and GraphQL requests
+	RateLimiter *RateLimiter
+	// CircuitBreaker, when set, fails requests fast while the Retraced API is degraded
+	CircuitBreaker *CircuitBreaker
+	// MaxRetries is the number of times a request is retried after a transport
+	// error, 429 or temporary 5xx response. Retrying an event after a transport
+	// error may store it twice.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled for every
+	// further retry, default is 100ms
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the delay between retries, default is 10 seconds
+	MaxRetryBackoff time.Duration
+
+	health endpointTracker
+	// authorization formats the Authorization header of a token, default is
+	// the Publisher API's "Token token=..." format
+	authorization func(token string) string
+	// timeout is set by WithTimeout and applied by New after all options
+	timeout *time.Duration
 }
 
 // NewClient creates a new retraced api client that can be used to send events
 func NewClient(endpoint string, projectID string, apiToken string) (*Client, error) {
-	ep := defaultEndpoint
-	if endpoint != "" {
-		ep = endpoint
-	}
-
-	return &Client{
-		projectID:  projectID,
-		token:      apiToken,
-		Endpoint:   ep,
-		HttpClient: http.DefaultClient,
-	}, nil
+	return New(projectID, apiToken, withOptionalEndpoint(endpoint))
 }
 
 // NewClientWithVersion Same as NewClient, but includes params for specifying the
 // Component and Version of the Retraced client application
 func NewClientWithVersion(endpoint string, projectID string, apiToken string, component string, version string) (*Client, error) {
-	ep := defaultEndpoint
-	if endpoint != "" {
-		ep = endpoint
+	return New(projectID, apiToken, withOptionalEndpoint(endpoint), WithComponent(component), WithVersion(version))
+}
+
+// doOnce sends an API request with the client's HttpClient, propagating the
+// trace context of the request's context. At debug level, the request and
+// response are dumped with the Authorization header redacted.
+func (c *Client) doOnce(req *http.Request) (*http.Response, error) {
+	logger := c.logger()
+	ctx := req.Context()
+	if tc, ok := TraceContextFromContext(ctx); ok {
+		req.Header.Set("traceparent", tc.String())
+	}
+	endpoint := c.endpointOf(req.URL.String())
+	var generation uint64
+	if c.CircuitBreaker != nil {
+		var err error
+		if generation, err = c.CircuitBreaker.allow(endpoint, time.Now()); err != nil {
+			c.metrics().IncCounter(MetricCircuitBreaker, "rejected")
+			return nil, err
+		}
+	}
+	debug := logger.Enabled(ctx, slog.LevelDebug)
+	dumpBodies := !hasSecretBodies(ctx)
+	if debug {
+		if dump, err := dumpRequest(req, dumpBodies); err == nil {
+			logger.DebugContext(ctx, "retraced: api request", "request", string(dump))
+		}
 	}
 
-	return &Client{
-		projectID:  projectID,
-		token:      apiToken,
-		Endpoint:   ep,
-		Component:  component,
-		Version:    version,
-		HttpClient: http.DefaultClient,
-	}, nil
+	resp, err := c.HttpClient.Do(req)
+	if ctx.Err() == nil {
+		c.recordEndpoint(req.URL.String(), resp, err)
+	}
+	if c.CircuitBreaker != nil {
+		outcome := outcomeSuccess
+		switch {
+		case ctx.Err() != nil:
+			outcome = outcomeIgnored
+		case err != nil || resp.StatusCode >= 500:
+			outcome = outcomeFailure
+		}
+		c.CircuitBreaker.record(endpoint, generation, outcome, time.Now())
+	}
+	if err != nil {
+		if debug {
+			logger.DebugContext(ctx, "retraced: api request failed", "method", req.Method, "url", req.URL.String(), "error", err)
+		}
+		return nil, err
+	}
+
+	if debug {
+		if dump, err := httputil.DumpResponse(resp, dumpBodies); err == nil {
+			logger.DebugContext(ctx, "retraced: api response", "response", string(dump))
+		}
+	}
+	return resp, nil
 }
 
 // NewEventRecord is returned from the Retraced API when an event is created
 type NewEventRecord struct {
 	ID   string `json:"id"`
 	Hash string `json:"hash"`
+	// Records holds, in DualWrite mode, the record of every endpoint that
+	// stored the event, keyed by endpoint
+	Records map[string]*NewEventRecord `json:"-"`
 }
 
 // ReportEvent is the method to call to send a new event.
+// With the default strict HashVerification policy, a hash mismatch is returned
+// as a *HashMismatchError that holds the stored event's record; the event must
+// not be reported again.
 func (c *Client) ReportEvent(event *Event) (*NewEventRecord, error) {
+	return c.reportEvent(context.Background(), event)
+}
+
+// ReportEventContext is like ReportEvent, but fills the event's missing Actor,
+// Group, SourceIP and Metadata from the audit scope carried by ctx, see
+// WithActor, WithGroup, WithSourceIP and WithMetadata. The context also
+// controls cancellation of the request.
+func (c *Client) ReportEventContext(ctx context.Context, event *Event) (*NewEventRecord, error) {
+	applyScope(ctx, event)
+	return c.reportEvent(ctx, event)
+}
+
+func (c *Client) reportEvent(ctx context.Context, event *Event) (record *NewEventRecord, err error) {
+	start := time.Now()
+	ctx, endSpan := c.trace(ctx, MetricReportEvent)
+	defer func() {
+		endSpan(err)
+		c.observe(MetricReportEvent, start, err)
+	}()
+
+	if tc, ok := TraceContextFromContext(ctx); ok && event.Metadata[TraceIDMetadataKey] == "" {
+		event.Metadata = withDefaults(event.Metadata, Fields{TraceIDMetadataKey: tc.TraceIDString()})
+	}
+	return c.publishEvent(ctx, event)
+}
+
+func (c *Client) publishEvent(ctx context.Context, event *Event) (*NewEventRecord, error) {
 	event.apiVersion = apiVersion
 	if event.Version == "" {
 		event.Version = c.Version
@@ -79,27 +221,86 @@ func (c *Client) ReportEvent(event *Event) (*NewEventRecord, error) {
 	if event.Component == "" {
 		event.Component = c.Component
 	}
+	for _, enrich := range c.Enrichers {
+		if err := enrich(ctx, event); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.rateLimit(ctx, MetricReportEvent, event); err != nil {
+		return nil, err
+	}
+	if c.Pseudonymizer != nil {
+		if err := c.Pseudonymizer.Pseudonymize(event); err != nil {
+			return nil, err
+		}
+	}
+	if c.Redactor != nil {
+		if err := c.Redactor.Redact(event); err != nil {
+			return nil, err
+		}
+	}
+	if c.Signer != nil {
+		c.Signer.Sign(event)
+	}
+	if c.Encryptor != nil {
+		if err := c.Encryptor.Encrypt(event); err != nil {
+			return nil, err
+		}
+	}
 
 	encoded, err := json.Marshal(event)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/publisher/v1/project/%s/event", c.Endpoint, c.projectID), bytes.NewBuffer(encoded))
+
+	var record *NewEventRecord
+	stored := []*NewEventRecord{}
+	if c.DualWrite && len(c.endpoints()) > 1 {
+		record, stored, err = c.dualWriteEvent(ctx, event, encoded)
+	} else {
+		record, err = c.failoverEvent(ctx, event, encoded)
+		if mismatch, ok := err.(*HashMismatchError); ok {
+			// Strict hash verification returns no record, but the event
+			// is stored.
+			stored = append(stored, mismatch.Record)
+		} else if err == nil {
+			stored = append(stored, record)
+		}
+	}
+	if err == ErrCircuitOpen && c.CircuitBreaker.Fallback != nil {
+		return c.CircuitBreaker.Fallback.ReportEvent(event)
+	}
+
+	if c.Ledger != nil {
+		// The event is already stored, so hand back the record with the error.
+		for _, r := range stored {
+			if _, err := c.Ledger.Append(r); err != nil {
+				return record, fmt.Errorf("recording receipt for event %s: %v", r.ID, err)
+			}
+		}
+	}
+
+	return record, err
+}
+
+// sendEvent publishes an encoded event to endpoint and verifies the returned
+// record.
+func (c *Client) sendEvent(ctx context.Context, endpoint string, event *Event, encoded []byte) (*NewEventRecord, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/publisher/v1/project/%s/event", endpoint, c.projectID), bytes.NewReader(encoded))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Token token=%s", c.token))
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("unexpected response from retraced api endpoint %s: %d", req.URL.String(), resp.StatusCode)
+		return nil, &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode}
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -112,8 +313,16 @@ func (c *Client) ReportEvent(event *Event) (*NewEventRecord, error) {
 		return nil, err
 	}
 
-	if err := event.VerifyHash(&reqResp); err != nil {
-		return nil, err
+	if c.HashVerification != HashVerificationOff {
+		if err := event.VerifyHashFormat(&reqResp, c.HashFormat); err != nil {
+			c.metrics().IncCounter(MetricHashVerification, MetricStatusMismatch)
+			if c.HashVerification == HashVerificationStrict {
+				return nil, err
+			}
+			c.logger().WarnContext(ctx, "retraced: event was stored but failed hash verification", "event_id", reqResp.ID, "endpoint", endpoint, "error", err)
+		} else {
+			c.metrics().IncCounter(MetricHashVerification, MetricStatusOK)
+		}
 	}
 
 	return &reqResp, nil
@@ -121,38 +330,60 @@ func (c *Client) ReportEvent(event *Event) (*NewEventRecord, error) {
 
 // GetViewerToken will return a one-time use token that can be used to view a group's audit log.
 func (c *Client) GetViewerToken(groupID string, isAdmin bool, actorID string, targetID string) (*ViewerToken, error) {
+	return c.GetViewerTokenContext(context.Background(), &ViewerTokenRequest{
+		GroupID:  groupID,
+		ActorID:  actorID,
+		IsAdmin:  isAdmin,
+		TargetID: targetID,
+	})
+}
+
+// GetViewerTokenContext is like GetViewerToken, but takes the token's scope as
+// a ViewerTokenRequest and makes the request with ctx.
+func (c *Client) GetViewerTokenContext(ctx context.Context, vtr *ViewerTokenRequest) (token *ViewerToken, err error) {
+	start := time.Now()
+	ctx, endSpan := c.trace(ctx, MetricViewerToken)
+	defer func() {
+		endSpan(err)
+		c.observe(MetricViewerToken, start, err)
+	}()
+
+	viewLogAction := vtr.ViewLogAction
+	if viewLogAction == "" {
+		viewLogAction = c.ViewLogAction
+	}
+
 	params := url.Values{}
-	params.Add("group_id", groupID)
-	params.Add("is_admin", strconv.FormatBool(isAdmin))
-	params.Add("actor_id", actorID)
-	params.Add("view_log_action", c.ViewLogAction)
+	params.Add("group_id", vtr.GroupID)
+	params.Add("is_admin", strconv.FormatBool(vtr.IsAdmin))
+	params.Add("actor_id", vtr.ActorID)
+	params.Add("view_log_action", viewLogAction)
 
-	if targetID != "" {
-		params.Add("target_id", targetID)
+	if vtr.TargetID != "" {
+		params.Add("target_id", vtr.TargetID)
 	}
 
-	u, err := url.Parse(fmt.Sprintf("%s/publisher/v1/project/%s/viewertoken", c.Endpoint, c.projectID))
+	u, err := url.Parse(fmt.Sprintf("%s/publisher/v1/project/%s/viewertoken", c.endpoint(), c.projectID))
 	if err != nil {
 		return nil, err
 	}
 	u.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(withSecretBodies(ctx), "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Token token=%s", c.token))
 
-	resp, err := c.HttpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK { // There's a pending PR in the retraced API to match this.
-		return nil, fmt.Errorf("unexpected response from retraced api endpoint %s: %d", req.URL.String(), resp.StatusCode)
+		return nil, &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode}
 	}
 
 	contents, err := io.ReadAll(resp.Body)
@@ -169,17 +400,18 @@ func (c *Client) GetViewerToken(groupID string, isAdmin bool, actorID string, ta
 }
 
 // DeleteViewerSessions will delete all viewer sessions for the given actor in the given group.
-func (c *Client) DeleteViewerSessions(groupID string, actorID string) error {
-	url := fmt.Sprintf("%s/v1/project/%s/group/%s/actor/%s/viewersessions", c.Endpoint, c.projectID, groupID, actorID)
+func (c *Client) DeleteViewerSessions(groupID string, actorID string) (err error) {
+	start := time.Now()
+	defer func() { c.observe(MetricDeleteViewerSessions, start, err) }()
+
+	url := fmt.Sprintf("%s/v1/project/%s/group/%s/actor/%s/viewersessions", c.endpoint(), c.projectID, groupID, actorID)
 
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Token token=%s", c.token))
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -194,14 +426,26 @@ func (c *Client) DeleteViewerSessions(groupID string, actorID string) error {
 
 // Query searches for events using the Publisher API's GraphQL endpoint.
 func (c *Client) Query(sq *StructuredQuery, mask *EventNodeMask, pageSize int) (EventsPager, error) {
-	url := fmt.Sprintf("%s/publisher/v1/project/%s/graphql", c.Endpoint, c.projectID)
+	return c.QueryContext(context.Background(), sq, mask, pageSize)
+}
+
+// QueryContext is like Query, but every page request is made with ctx.
+func (c *Client) QueryContext(ctx context.Context, sq *StructuredQuery, mask *EventNodeMask, pageSize int) (EventsPager, error) {
+	url := fmt.Sprintf("%s/publisher/v1/project/%s/graphql", c.endpoint(), c.projectID)
+	return c.query(ctx, url, sq, mask, pageSize, nil)
+}
+
+// query fetches the first page of a search at the GraphQL endpoint url. When
+// keep is set, only the events it returns true for are part of the results.
+func (c *Client) query(ctx context.Context, url string, sq *StructuredQuery, mask *EventNodeMask, pageSize int, keep func(*EventNode) bool) (EventsPager, error) {
 	ec := &EventsConnection{
+		ctx:             ctx,
 		url:             url,
-		authorization:   fmt.Sprintf("Token token=%s", c.token),
 		structuredQuery: sq,
 		mask:            mask,
 		pageSize:        pageSize,
-		httpClient:      c.HttpClient,
+		keep:            keep,
+		client:          c,
 	}
 
 	err := ec.call()
@@ -1,6 +1,15 @@
 package retraced
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 // Initialize a new client with your projectID and API key and then configure options.
 func ExampleClient() {
@@ -12,3 +21,53 @@ func ExampleClient() {
 	client.Version = "0.3.0"
 	client.ViewLogAction = "audit.log.view"
 }
+
+// newCreatedServer starts a server that stores every event as "abc" with the
+// given hash, and returns a client for it. inspect, if set, sees each request
+// first. An empty hash can't be verified, so hash verification is turned off.
+func newCreatedServer(t *testing.T, hash string, inspect func(r *http.Request)) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inspect != nil {
+			inspect(r)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":"abc","hash":%q}`, hash)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, "dev", "dev")
+	require.NoError(t, err)
+	if hash == "" {
+		client.HashVerification = HashVerificationOff
+	}
+	return client
+}
+
+func TestReportEventHashVerification(t *testing.T) {
+	client := newCreatedServer(t, "not-the-hash", nil)
+
+	_, err := client.ReportEvent(&Event{Action: "a.test"})
+	mismatch, ok := err.(*HashMismatchError)
+	require.True(t, ok, "expected *HashMismatchError, got %v", err)
+	assert.Equal(t, "abc", mismatch.Record.ID)
+
+	client.HashVerification = HashVerificationWarn
+	record, err := client.ReportEvent(&Event{Action: "a.test"})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", record.ID)
+
+	client.HashVerification = HashVerificationOff
+	_, err = client.ReportEvent(&Event{Action: "a.test"})
+	assert.NoError(t, err)
+}
+
+func TestReportEventHashMismatchLedger(t *testing.T) {
+	client := newCreatedServer(t, "not-the-hash", nil)
+	client.Ledger = NewReceiptLedger(nil)
+
+	record, err := client.ReportEvent(&Event{Action: "a.test"})
+	assert.Nil(t, record)
+	_, ok := err.(*HashMismatchError)
+	require.True(t, ok, "expected *HashMismatchError, got %v", err)
+	assert.Equal(t, uint64(1), client.Ledger.Size(), "the stored event has a receipt")
+}
@@ -0,0 +1,169 @@
+package retraced
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const encryptedValuePrefix = "enc:v1:"
+
+// ErrKeyNotFound is returned by a KeyProvider that does not hold the requested key.
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// KeyProvider supplies the AES keys used for field-level encryption. Keys are
+// identified by ids that are embedded in every ciphertext, so values encrypted
+// under an old key can still be decrypted after rotation.
+type KeyProvider interface {
+	// CurrentKey returns the key new values are encrypted with
+	CurrentKey() (keyID string, key []byte, err error)
+	// Key returns the key with the given id, or ErrKeyNotFound
+	Key(keyID string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider backed by a fixed set of keys.
+type StaticKeyProvider struct {
+	// CurrentKeyID is the id of the key used to encrypt new values
+	CurrentKeyID string
+	// Keys maps key ids to 16, 24 or 32 byte AES keys
+	Keys map[string][]byte
+}
+
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	key, err := p.Key(p.CurrentKeyID)
+	return p.CurrentKeyID, key, err
+}
+
+func (p *StaticKeyProvider) Key(keyID string) ([]byte, error) {
+	key, ok := p.Keys[keyID]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// FieldEncryptor encrypts selected Fields and Metadata values with AES-GCM
+// before events are reported, and decrypts them in query results.
+type FieldEncryptor struct {
+	Keys KeyProvider
+	// Fields lists the Fields keys to encrypt
+	Fields []string
+	// Metadata lists the Metadata keys to encrypt
+	Metadata []string
+}
+
+// Encrypt replaces the selected values of the event with ciphertext. The
+// Fields and Metadata maps are replaced rather than modified.
+func (e *FieldEncryptor) Encrypt(event *Event) error {
+	keyID, key, err := e.Keys.CurrentKey()
+	if err != nil {
+		return fmt.Errorf("getting current encryption key: %v", err)
+	}
+	if strings.Contains(keyID, ":") {
+		return fmt.Errorf("invalid encryption key id %q", keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	if event.Fields, err = encryptValues(aead, keyID, "fields", event.Fields, e.Fields); err != nil {
+		return err
+	}
+	if event.Metadata, err = encryptValues(aead, keyID, "metadata", event.Metadata, e.Metadata); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Decrypt replaces the encrypted values of the selected Fields and Metadata
+// keys of the node with their plaintext. Values of other keys, and values
+// encrypted under a key the KeyProvider does not hold, are left unchanged. A
+// value that fails to decrypt is left as ciphertext, and its error is returned
+// after the other values have been decrypted.
+func (e *FieldEncryptor) Decrypt(node *EventNode) error {
+	return errors.Join(
+		e.decryptValues("fields", node.Fields, e.Fields),
+		e.decryptValues("metadata", node.Metadata, e.Metadata),
+	)
+}
+
+func encryptValues(aead cipher.AEAD, keyID, scope string, values Fields, keys []string) (Fields, error) {
+	if len(values) == 0 || len(keys) == 0 {
+		return values, nil
+	}
+	encrypted := make(Fields, len(values))
+	for k, v := range values {
+		encrypted[k] = v
+	}
+	for _, k := range keys {
+		v, ok := values[k]
+		if !ok {
+			continue
+		}
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(v)+aead.Overhead())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(v), []byte(scope+":"+k))
+		encrypted[k] = encryptedValuePrefix + keyID + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+	}
+	return encrypted, nil
+}
+
+func (e *FieldEncryptor) decryptValues(scope string, values Fields, keys []string) error {
+	var errs []error
+	for _, k := range keys {
+		v, ok := values[k]
+		if !ok || !strings.HasPrefix(v, encryptedValuePrefix) {
+			continue
+		}
+		plaintext, err := e.decryptValue(scope, k, v)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values[k] = plaintext
+	}
+	return errors.Join(errs...)
+}
+
+func (e *FieldEncryptor) decryptValue(scope, k, v string) (string, error) {
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(v, encryptedValuePrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value for %s key %q", scope, k)
+	}
+	key, err := e.Keys.Key(keyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return v, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value for %s key %q", scope, k)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(scope+":"+k))
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s key %q: %v", scope, k, err)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,82 @@
+package retraced
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldEncryptionRotation(t *testing.T) {
+	keys := &StaticKeyProvider{
+		CurrentKeyID: "k1",
+		Keys:         map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+	}
+	enc := &FieldEncryptor{Keys: keys, Fields: []string{"old", "new"}, Metadata: []string{"secret"}}
+
+	original := Fields{"old": "a", "new": "b", "plain": "c"}
+	event := &Event{Action: "config.update", Fields: original, Metadata: Fields{"secret": "s"}}
+	require.NoError(t, enc.Encrypt(event))
+	assert.True(t, strings.HasPrefix(event.Fields["old"], "enc:v1:k1:"))
+	assert.Equal(t, "c", event.Fields["plain"])
+	assert.Equal(t, "a", original["old"], "caller's map must not be modified")
+
+	// Rotate to a new key; values under k1 still decrypt.
+	keys.Keys["k2"] = bytes.Repeat([]byte{2}, 32)
+	keys.CurrentKeyID = "k2"
+
+	node := &EventNode{Fields: event.Fields, Metadata: event.Metadata}
+	require.NoError(t, enc.Decrypt(node))
+	assert.Equal(t, Fields{"old": "a", "new": "b", "plain": "c"}, node.Fields)
+	assert.Equal(t, Fields{"secret": "s"}, node.Metadata)
+
+	// Values are bound to their key, so moving ciphertext between keys fails.
+	event = &Event{Action: "config.update", Fields: Fields{"old": "a", "new": "b"}}
+	require.NoError(t, enc.Encrypt(event))
+	node = &EventNode{Fields: Fields{"old": event.Fields["new"]}}
+	assert.Error(t, enc.Decrypt(node))
+
+	// Unknown keys are left encrypted.
+	delete(keys.Keys, "k2")
+	node = &EventNode{Fields: Fields{"old": event.Fields["old"]}}
+	require.NoError(t, enc.Decrypt(node))
+	assert.Equal(t, event.Fields["old"], node.Fields["old"])
+}
+
+func TestFieldDecryptionOnlyConfiguredKeys(t *testing.T) {
+	keys := &StaticKeyProvider{
+		CurrentKeyID: "k1",
+		Keys:         map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+	}
+	enc := &FieldEncryptor{Keys: keys, Fields: []string{"secret", "broken"}}
+	event := &Event{Action: "a", Fields: Fields{"secret": "s"}}
+	require.NoError(t, enc.Encrypt(event))
+
+	node := &EventNode{ID: "e1", Fields: Fields{
+		"secret":  event.Fields["secret"],
+		"broken":  "enc:v1:k1:not-base64!",
+		"comment": "enc:v1:looks encrypted",
+	}}
+	assert.Error(t, enc.Decrypt(node))
+	assert.Equal(t, "s", node.Fields["secret"])
+	assert.Equal(t, "enc:v1:k1:not-base64!", node.Fields["broken"])
+	assert.Equal(t, "enc:v1:looks encrypted", node.Fields["comment"])
+
+	// A value that fails to decrypt doesn't fail the page.
+	server := searchServer(
+		&EventNode{ID: "e1", Fields: Fields{"broken": "enc:v1:k1:not-base64!"}},
+		&EventNode{ID: "e2", Fields: Fields{"secret": event.Fields["secret"]}},
+	)
+	defer server.Close()
+	client, err := NewClient(server.URL, "p", "token")
+	require.NoError(t, err)
+	client.Encryptor = enc
+	pager, err := client.Query(&StructuredQuery{}, &EventNodeMask{ID: true, Fields: true}, 10)
+	require.NoError(t, err)
+	results := pager.CurrentResults()
+	require.Len(t, results, 2)
+	assert.Equal(t, "enc:v1:k1:not-base64!", results[0].Fields["broken"])
+	assert.Equal(t, "s", results[1].Fields["secret"])
+}
@@ -0,0 +1,287 @@
+package retraced
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricStatusFailover counts ReportEvent attempts that failed over to the
+// next endpoint.
+const MetricStatusFailover = "failover"
+
+const defaultEndpointCoolDown = 30 * time.Second
+
+// StatusError is returned when the Retraced API responds with an unexpected
+// status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response from retraced api endpoint %s: %d", e.URL, e.StatusCode)
+}
+
+// EndpointHealth is the health of one of a Client's endpoints.
+type EndpointHealth struct {
+	Endpoint string
+	// Healthy is false while the endpoint is cooling down after a failure
+	Healthy bool
+	// ConsecutiveFailures is reset by the next successful request
+	ConsecutiveFailures int
+	LastFailure         time.Time
+	LastError           error
+}
+
+// DualWriteError is returned by ReportEvent in dual-write mode when an event
+// could not be stored on every endpoint. Records holds the records of the
+// endpoints that stored the event, and Errors the errors of those that did
+// not, keyed by endpoint. An endpoint that stored the event but failed strict
+// hash verification is in both.
+type DualWriteError struct {
+	Records map[string]*NewEventRecord
+	Errors  map[string]error
+}
+
+func (e *DualWriteError) Error() string {
+	endpoints := make([]string, 0, len(e.Errors))
+	for endpoint := range e.Errors {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	failures := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		failures[i] = fmt.Sprintf("%s: %v", endpoint, e.Errors[endpoint])
+	}
+	total := len(e.Records)
+	for endpoint := range e.Errors {
+		if _, ok := e.Records[endpoint]; !ok {
+			total++
+		}
+	}
+	return fmt.Sprintf("retraced: dual write failed on %d of %d endpoints: %s",
+		len(e.Errors), total, strings.Join(failures, "; "))
+}
+
+// endpointTracker holds the health of a Client's endpoints.
+type endpointTracker struct {
+	states map[string]*EndpointHealth
+	mtx    sync.Mutex
+}
+
+// endpoints returns the configured endpoints in order of preference.
+func (c *Client) endpoints() []string {
+	if len(c.Endpoints) > 0 {
+		return c.Endpoints
+	}
+	return []string{c.Endpoint}
+}
+
+func (c *Client) endpointCoolDown() time.Duration {
+	if c.EndpointCoolDown <= 0 {
+		return defaultEndpointCoolDown
+	}
+	return c.EndpointCoolDown
+}
+
+// EndpointHealth returns the health of the client's endpoints in configured
+// order.
+func (c *Client) EndpointHealth() []EndpointHealth {
+	now := time.Now()
+	c.health.mtx.Lock()
+	defer c.health.mtx.Unlock()
+
+	endpoints := c.endpoints()
+	health := make([]EndpointHealth, len(endpoints))
+	for i, endpoint := range endpoints {
+		health[i] = c.endpointHealth(endpoint, now)
+	}
+	return health
+}
+
+// endpointHealth must be called with c.health.mtx held.
+func (c *Client) endpointHealth(endpoint string, now time.Time) EndpointHealth {
+	state, ok := c.health.states[endpoint]
+	if !ok {
+		return EndpointHealth{Endpoint: endpoint, Healthy: true}
+	}
+	health := *state
+	health.Healthy = health.ConsecutiveFailures == 0 || now.Sub(health.LastFailure) >= c.endpointCoolDown()
+	return health
+}
+
+// healthyEndpoints returns the endpoints ordered by health: healthy endpoints
+// in configured order, then unhealthy endpoints starting with the one that
+// failed longest ago.
+func (c *Client) healthyEndpoints() []string {
+	health := c.EndpointHealth()
+	sort.SliceStable(health, func(i, j int) bool {
+		if health[i].Healthy != health[j].Healthy {
+			return health[i].Healthy
+		}
+		return !health[i].Healthy && health[i].LastFailure.Before(health[j].LastFailure)
+	})
+	endpoints := make([]string, len(health))
+	for i, h := range health {
+		endpoints[i] = h.Endpoint
+	}
+	return endpoints
+}
+
+// endpoint returns the healthiest endpoint.
+func (c *Client) endpoint() string {
+	return c.healthyEndpoints()[0]
+}
+
+// recordEndpoint updates the health of the endpoint that served rawURL.
+// Transport errors, 429 and 5xx responses count as failures.
+func (c *Client) recordEndpoint(rawURL string, resp *http.Response, err error) {
+	if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		c.updateEndpoint(rawURL, nil)
+		return
+	}
+	if err == nil {
+		err = &StatusError{URL: rawURL, StatusCode: resp.StatusCode}
+	}
+	c.updateEndpoint(rawURL, err)
+}
+
+func (c *Client) updateEndpoint(rawURL string, err error) {
+	endpoint := c.endpointOf(rawURL)
+	if endpoint == "" {
+		return
+	}
+
+	c.health.mtx.Lock()
+	defer c.health.mtx.Unlock()
+	if c.health.states == nil {
+		c.health.states = map[string]*EndpointHealth{}
+	}
+	state, ok := c.health.states[endpoint]
+	if !ok {
+		state = &EndpointHealth{Endpoint: endpoint}
+		c.health.states[endpoint] = state
+	}
+	if err == nil {
+		state.ConsecutiveFailures = 0
+	} else {
+		state.ConsecutiveFailures++
+		state.LastFailure = time.Now()
+		state.LastError = err
+	}
+}
+
+// endpointOf returns the configured endpoint that serves rawURL, or "" if
+// there is none.
+func (c *Client) endpointOf(rawURL string) string {
+	for _, endpoint := range c.endpoints() {
+		if strings.HasPrefix(rawURL, endpoint+"/") {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// shouldFailover reports whether a request that failed with err may be sent
+// to another endpoint: the event was not stored and another region may
+// accept it. Only errors that mean the endpoint did not process the event
+// qualify: an open circuit, a failed dial, 429 and 503. After other transport
+// errors and 5xx responses the event may have been stored, so failing over
+// could store it twice.
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == ErrCircuitOpen {
+		return true
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode == http.StatusServiceUnavailable
+	}
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// failoverEvent sends the encoded event to each endpoint in order of health
+// until one stores it.
+func (c *Client) failoverEvent(ctx context.Context, event *Event, encoded []byte) (record *NewEventRecord, err error) {
+	endpoints := c.healthyEndpoints()
+	for i, endpoint := range endpoints {
+		record, err = c.sendEvent(ctx, endpoint, event, encoded)
+		if err == nil || i == len(endpoints)-1 || !shouldFailover(ctx, err) {
+			break
+		}
+		c.metrics().IncCounter(MetricReportEvent, MetricStatusFailover)
+		c.logger().WarnContext(ctx, "retraced: failing over to next endpoint", "endpoint", endpoint, "error", err)
+	}
+	return record, err
+}
+
+// dualWriteEvent sends the encoded event to all endpoints concurrently. It
+// returns the record of the first endpoint in configured order that stored
+// the event, with the records of all endpoints in its Records, along with
+// every record in configured order. An endpoint that failed strict hash
+// verification stored the event, so its record is included too.
+func (c *Client) dualWriteEvent(ctx context.Context, event *Event, encoded []byte) (*NewEventRecord, []*NewEventRecord, error) {
+	endpoints := c.endpoints()
+	records := make([]*NewEventRecord, len(endpoints))
+	errs := make([]error, len(endpoints))
+
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			records[i], errs[i] = c.sendEvent(ctx, endpoint, event, encoded)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	var primary *NewEventRecord
+	var stored []*NewEventRecord
+	dwErr := &DualWriteError{Records: map[string]*NewEventRecord{}, Errors: map[string]error{}}
+	for i, endpoint := range endpoints {
+		if mismatch, ok := errs[i].(*HashMismatchError); ok {
+			records[i] = mismatch.Record
+		}
+		if errs[i] != nil {
+			dwErr.Errors[endpoint] = errs[i]
+		}
+		if records[i] == nil {
+			continue
+		}
+		if primary == nil {
+			primary = records[i]
+		}
+		stored = append(stored, records[i])
+		dwErr.Records[endpoint] = records[i]
+	}
+
+	if primary != nil {
+		primary.Records = dwErr.Records
+	}
+	if len(dwErr.Errors) == 0 {
+		return primary, stored, nil
+	}
+	if primary == nil && allCircuitOpen(errs) {
+		return nil, nil, ErrCircuitOpen
+	}
+	return primary, stored, dwErr
+}
+
+func allCircuitOpen(errs []error) bool {
+	for _, err := range errs {
+		if err != ErrCircuitOpen {
+			return false
+		}
+	}
+	return true
+}
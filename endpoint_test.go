@@ -0,0 +1,174 @@
+package retraced
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// regionServer stores events with the given id while status is 201, and
+// fails with status otherwise.
+type regionServer struct {
+	*httptest.Server
+	status int32
+	calls  int32
+}
+
+func newRegionServer(id string) *regionServer {
+	s := &regionServer{status: http.StatusCreated}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.calls, 1)
+		status := int(atomic.LoadInt32(&s.status))
+		w.WriteHeader(status)
+		if status == http.StatusCreated || status == http.StatusOK {
+			fmt.Fprintf(w, `{"id":%q,"hash":"h"}`, id)
+		}
+	}))
+	return s
+}
+
+func TestReportEventFailover(t *testing.T) {
+	east, west := newRegionServer("east"), newRegionServer("west")
+	defer east.Close()
+	defer west.Close()
+
+	client, err := NewClient("", "dev", "dev")
+	require.NoError(t, err)
+	client.Endpoints = []string{east.URL, west.URL}
+	client.HashVerification = HashVerificationOff
+
+	atomic.StoreInt32(&east.status, http.StatusServiceUnavailable)
+	record, err := client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "west", record.ID)
+
+	health := client.EndpointHealth()
+	assert.False(t, health[0].Healthy)
+	assert.Equal(t, 1, health[0].ConsecutiveFailures)
+	assert.True(t, health[1].Healthy)
+
+	// The unhealthy endpoint is skipped until its cool-down has passed.
+	record, err = client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "west", record.ID)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&east.calls))
+	assert.Equal(t, west.URL, client.endpoint())
+
+	// Client errors are not retried on another endpoint.
+	atomic.StoreInt32(&west.status, http.StatusBadRequest)
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	status, ok := err.(*StatusError)
+	require.True(t, ok, "expected *StatusError, got %v", err)
+	assert.Equal(t, http.StatusBadRequest, status.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&east.calls))
+}
+
+func TestReportEventFailoverAmbiguousErrors(t *testing.T) {
+	east, west := newRegionServer("east"), newRegionServer("west")
+	defer west.Close()
+
+	client, err := NewClient("", "dev", "dev")
+	require.NoError(t, err)
+	client.Endpoints = []string{east.URL, west.URL}
+	client.EndpointCoolDown = time.Nanosecond
+	client.HashVerification = HashVerificationOff
+
+	// east may have stored the event before responding 502.
+	atomic.StoreInt32(&east.status, http.StatusBadGateway)
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	status, ok := err.(*StatusError)
+	require.True(t, ok, "expected *StatusError, got %v", err)
+	assert.Equal(t, http.StatusBadGateway, status.StatusCode)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&west.calls))
+
+	// A refused connection never reached east.
+	east.Close()
+	record, err := client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "west", record.ID)
+}
+
+func TestReportEventFailoverCircuitBreaker(t *testing.T) {
+	east, west := newRegionServer("east"), newRegionServer("west")
+	defer east.Close()
+	defer west.Close()
+
+	client, err := NewClient("", "dev", "dev")
+	require.NoError(t, err)
+	client.Endpoints = []string{east.URL, west.URL}
+	client.EndpointCoolDown = time.Nanosecond
+	client.HashVerification = HashVerificationOff
+	client.CircuitBreaker = &CircuitBreaker{MinRequests: 1, CoolDown: time.Hour}
+
+	atomic.StoreInt32(&east.status, http.StatusServiceUnavailable)
+	for i := 0; i < 2; i++ {
+		record, err := client.ReportEvent(&Event{Action: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, "west", record.ID)
+	}
+	// The open circuit of east fails over without contacting east.
+	assert.Equal(t, int32(1), atomic.LoadInt32(&east.calls))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&west.calls))
+	assert.Equal(t, CircuitOpen, client.CircuitBreaker.State(east.URL))
+	assert.Equal(t, CircuitClosed, client.CircuitBreaker.State(west.URL))
+}
+
+func TestReportEventDualWrite(t *testing.T) {
+	east, west := newRegionServer("east"), newRegionServer("west")
+	defer east.Close()
+	defer west.Close()
+
+	client, err := NewClient("", "dev", "dev")
+	require.NoError(t, err)
+	client.Endpoints = []string{east.URL, west.URL}
+	client.DualWrite = true
+	client.HashVerification = HashVerificationOff
+	client.Ledger = NewReceiptLedger(nil)
+
+	record, err := client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "east", record.ID)
+	require.Len(t, record.Records, 2)
+	assert.Equal(t, "east", record.Records[east.URL].ID)
+	assert.Equal(t, "west", record.Records[west.URL].ID)
+	assert.Equal(t, uint64(2), client.Ledger.Size())
+
+	atomic.StoreInt32(&east.status, http.StatusInternalServerError)
+	record, err = client.ReportEvent(&Event{Action: "a"})
+	dwErr, ok := err.(*DualWriteError)
+	require.True(t, ok, "expected *DualWriteError, got %v", err)
+	assert.Equal(t, "west", record.ID)
+	assert.Contains(t, dwErr.Errors, east.URL)
+	assert.Equal(t, "west", dwErr.Records[west.URL].ID)
+	assert.Equal(t, dwErr.Records, record.Records)
+	assert.Equal(t, uint64(3), client.Ledger.Size())
+}
+
+func TestReportEventDualWriteHashMismatch(t *testing.T) {
+	east, west := newRegionServer("east"), newRegionServer("west")
+	defer east.Close()
+	defer west.Close()
+
+	client, err := NewClient("", "dev", "dev")
+	require.NoError(t, err)
+	client.Endpoints = []string{east.URL, west.URL}
+	client.DualWrite = true
+	client.Ledger = NewReceiptLedger(nil)
+
+	// Both endpoints store the event, but return a hash that doesn't match.
+	record, err := client.ReportEvent(&Event{Action: "a"})
+	dwErr, ok := err.(*DualWriteError)
+	require.True(t, ok, "expected *DualWriteError, got %v", err)
+	require.NotNil(t, record)
+	assert.Equal(t, "east", record.ID)
+	assert.Equal(t, "west", dwErr.Records[west.URL].ID)
+	assert.IsType(t, &HashMismatchError{}, dwErr.Errors[east.URL])
+	assert.Equal(t, uint64(2), client.Ledger.Size())
+	assert.Contains(t, err.Error(), "failed on 2 of 2 endpoints")
+}
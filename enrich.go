@@ -0,0 +1,143 @@
+package retraced
+
+import (
+	"context"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// Enricher adds information to an event before it is reported. Enrichers run
+// in order, after the Client's Component and Version are applied and before
+// any Pseudonymizer, Redactor, Signer or Encryptor, and should only fill
+// values the caller left empty.
+type Enricher func(ctx context.Context, event *Event) error
+
+// withDefaults returns a copy of fields with values added for missing keys.
+func withDefaults(fields Fields, values Fields) Fields {
+	merged := make(Fields, len(fields)+len(values))
+	for k, v := range values {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+// StaticMetadata adds values to every event's Metadata.
+func StaticMetadata(values Fields) Enricher {
+	return func(ctx context.Context, event *Event) error {
+		event.Metadata = withDefaults(event.Metadata, values)
+		return nil
+	}
+}
+
+// StaticFields adds values to every event's Fields.
+func StaticFields(values Fields) Enricher {
+	return func(ctx context.Context, event *Event) error {
+		event.Fields = withDefaults(event.Fields, values)
+		return nil
+	}
+}
+
+// HostnameMetadata adds the host name to Metadata under key.
+func HostnameMetadata(key string) Enricher {
+	hostname, err := os.Hostname()
+	return func(ctx context.Context, event *Event) error {
+		if err != nil {
+			return err
+		}
+		event.Metadata = withDefaults(event.Metadata, Fields{key: hostname})
+		return nil
+	}
+}
+
+// EnvMetadata adds the value of an environment variable, such as the region,
+// to Metadata under key. Nothing is added if the variable is unset.
+func EnvMetadata(key, envVar string) Enricher {
+	value := os.Getenv(envVar)
+	return func(ctx context.Context, event *Event) error {
+		if value != "" {
+			event.Metadata = withDefaults(event.Metadata, Fields{key: value})
+		}
+		return nil
+	}
+}
+
+// ContextMetadata adds the value returned by fn, such as a request id carried
+// by ctx, to Metadata under key. Nothing is added if fn returns "".
+func ContextMetadata(key string, fn func(ctx context.Context) string) Enricher {
+	return func(ctx context.Context, event *Event) error {
+		if value := fn(ctx); value != "" {
+			event.Metadata = withDefaults(event.Metadata, Fields{key: value})
+		}
+		return nil
+	}
+}
+
+// DefaultSourceIP fills a missing SourceIP with the value returned by fn.
+func DefaultSourceIP(fn func(ctx context.Context) string) Enricher {
+	return func(ctx context.Context, event *Event) error {
+		if event.SourceIP == "" {
+			event.SourceIP = fn(ctx)
+		}
+		return nil
+	}
+}
+
+// DefaultCreated fills a missing Created timestamp with the current time.
+func DefaultCreated() Enricher {
+	return func(ctx context.Context, event *Event) error {
+		if event.Created.IsZero() {
+			event.Created = time.Now().UTC()
+		}
+		return nil
+	}
+}
+
+// DefaultComponentVersion fills a missing Component and Version.
+func DefaultComponentVersion(component, version string) Enricher {
+	return func(ctx context.Context, event *Event) error {
+		if event.Component == "" {
+			event.Component = component
+		}
+		if event.Version == "" {
+			event.Version = version
+		}
+		return nil
+	}
+}
+
+// BuildInfoMetadata adds the main module path and version, the VCS revision
+// and the Go version from runtime/debug.ReadBuildInfo to Metadata, with keys
+// prefixed by "build.". A Version set by neither the event nor the Client is
+// filled with the VCS revision.
+func BuildInfoMetadata() Enricher {
+	values := Fields{}
+	var revision string
+	if info, ok := debug.ReadBuildInfo(); ok {
+		values["build.module"] = info.Main.Path
+		values["build.module_version"] = info.Main.Version
+		values["build.go_version"] = info.GoVersion
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+				values["build.vcs_revision"] = revision
+			}
+		}
+	}
+	for k, v := range values {
+		if v == "" {
+			delete(values, k)
+		}
+	}
+
+	return func(ctx context.Context, event *Event) error {
+		event.Metadata = withDefaults(event.Metadata, values)
+		if event.Version == "" {
+			event.Version = revision
+		}
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package retraced
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type requestIDKey struct{}
+
+func TestEnrichers(t *testing.T) {
+	client := newCreatedServer(t, "", nil)
+	client.Enrichers = []Enricher{
+		StaticMetadata(Fields{"region": "eu-west-1", "env": "prod"}),
+		ContextMetadata("request_id", func(ctx context.Context) string {
+			id, _ := ctx.Value(requestIDKey{}).(string)
+			return id
+		}),
+		DefaultSourceIP(func(ctx context.Context) string { return "10.0.0.1" }),
+		DefaultCreated(),
+		DefaultComponentVersion("billing", "1.2.3"),
+	}
+
+	event := &Event{Action: "invoice.create", Metadata: Fields{"env": "staging"}}
+	_, err := client.reportEvent(context.WithValue(context.Background(), requestIDKey{}, "req-1"), event)
+	require.NoError(t, err)
+
+	assert.Equal(t, Fields{"region": "eu-west-1", "env": "staging", "request_id": "req-1"}, event.Metadata)
+	assert.Equal(t, "10.0.0.1", event.SourceIP)
+	assert.False(t, event.Created.IsZero())
+	assert.Equal(t, "billing", event.Component)
+	assert.Equal(t, "1.2.3", event.Version)
+
+	// The client's Component and Version are applied before enrichers.
+	client.Component = "search"
+	client.Version = "2.0.0"
+	event = &Event{Action: "invoice.create"}
+	_, err = client.reportEvent(context.Background(), event)
+	require.NoError(t, err)
+	assert.Equal(t, "search", event.Component)
+	assert.Equal(t, "2.0.0", event.Version)
+}
@@ -1,11 +1,7 @@
 package retraced
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 )
@@ -65,102 +61,25 @@ type Event struct {
 // VerifyHash computes a hash of the sent event, and verifies
 // that it matches the hash we got back from Retraced API
 func (event *Event) VerifyHash(newEvent *NewEventRecord) error {
+	return event.VerifyHashFormat(newEvent, HashFormatDefault)
+}
+
+// VerifyHashFormat is like VerifyHash, but builds the hash target in the
+// given format.
+func (event *Event) VerifyHashFormat(newEvent *NewEventRecord, format HashFormat) error {
 	// Basic sanity check
 	if event.Action == "" {
 		return fmt.Errorf("missing required field for hash verification: Action")
 	}
 
-	hashTarget := event.BuildHashTarget(newEvent)
-
-	hashBytes := sha256.Sum256(hashTarget)
-	result := hex.EncodeToString(hashBytes[:])
-
-	if result != newEvent.Hash {
-		return fmt.Errorf("hash mismatch: local[%s] != remote[%s]", result, newEvent.Hash)
-	}
-
-	return nil
+	return event.verifyHash(newEvent, format)
 }
 
 // BuildHashTarget builds a string that will be used to
 // compute a hash of the event
 func (event *Event) BuildHashTarget(newEvent *NewEventRecord) []byte {
-
-	concat := &bytes.Buffer{}
-	fmt.Fprintf(concat, "%s:", encodePassOne(newEvent.ID))
-	fmt.Fprintf(concat, "%s:", encodePassOne(event.Action))
-
-	targetId := ""
-	if event.Target != nil {
-		targetId = event.Target.ID
-	}
-	fmt.Fprintf(concat, "%s:", encodePassOne(targetId))
-
-	actorId := ""
-	if event.Actor != nil {
-		actorId = event.Actor.ID
-	}
-	fmt.Fprintf(concat, "%s:", encodePassOne(actorId))
-
-	groupId := ""
-	if event.Group != nil {
-		groupId = event.Group.ID
-	}
-	fmt.Fprintf(concat, "%s:", encodePassOne(groupId))
-
-	fmt.Fprintf(concat, "%s:", encodePassOne(event.SourceIP))
-
-	if event.IsFailure {
-		fmt.Fprint(concat, "1:")
-	} else {
-		fmt.Fprint(concat, "0:")
-	}
-	if event.IsAnonymous {
-		fmt.Fprint(concat, "1:")
-	} else {
-		fmt.Fprint(concat, "0:")
-	}
-
-	if len(event.Fields) == 0 {
-		fmt.Fprintf(concat, ":")
-	} else {
-		allKeys := []string{}
-		for k := range event.Fields {
-			allKeys = append(allKeys, k)
-		}
-		sort.Strings(allKeys)
-		for i := 0; i < len(allKeys); i++ {
-			k := allKeys[i]
-			v := event.Fields[k]
-
-			encodedKey := encodePassTwo(encodePassOne(k))
-			encodedValue := encodePassTwo(encodePassOne(v))
-			fmt.Fprintf(concat, "%s=%s;", encodedKey, encodedValue)
-		}
-	}
-
-	if event.ExternalID != "" {
-		fmt.Fprintf(concat, ":%s", encodePassOne(event.ExternalID))
-	}
-
-	if len(event.Metadata) > 0 {
-		fmt.Fprintf(concat, ":")
-		allKeys := []string{}
-		for k := range event.Metadata {
-			allKeys = append(allKeys, k)
-		}
-		sort.Strings(allKeys)
-		for i := 0; i < len(allKeys); i++ {
-			k := allKeys[i]
-			v := event.Metadata[k]
-
-			encodedKey := encodePassTwo(encodePassOne(k))
-			encodedValue := encodePassTwo(encodePassOne(v))
-			fmt.Fprintf(concat, "%s=%s;", encodedKey, encodedValue)
-		}
-	}
-
-	return concat.Bytes()
+	hashTarget, _ := event.AppendHashTarget(nil, newEvent, HashFormatDefault)
+	return hashTarget
 }
 
 func encodePassOne(in string) string {
@@ -24,11 +24,13 @@ func TestHashMismatch(t *testing.T) {
 		ID:   "0123456789abcdefg",
 		Hash: "XXXXXXXXX",
 	}
-	if err := testEvent.VerifyHash(fakeNew); err != nil {
-		// pass
-	} else {
-		t.Errorf("Hash check should have failed")
+	err := testEvent.VerifyHash(fakeNew)
+	mismatch, ok := err.(*HashMismatchError)
+	if !ok {
+		t.Fatalf("Hash check should have failed with *HashMismatchError, got %v", err)
 	}
+	assert.Equal(t, fakeNew, mismatch.Record)
+	assert.Equal(t, string(testEvent.BuildHashTarget(fakeNew)), string(mismatch.HashTarget))
 }
 
 func TestHashMatch(t *testing.T) {
@@ -1,6 +1,6 @@
 module github.com/retracedhq/retraced-go
 
-go 1.19
+go 1.21
 
 require (
 	github.com/satori/go.uuid v1.2.0
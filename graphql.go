@@ -2,11 +2,11 @@ package retraced
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -582,14 +582,15 @@ type graphQLSearchRoot struct {
 
 // EventsConnection handles cursor-based pagination over query results.
 type EventsConnection struct {
+	ctx             context.Context
 	url             string
-	authorization   string
 	structuredQuery *StructuredQuery
 	mask            *EventNodeMask
 	cursors         []string
 	pageSize        int
+	keep            func(*EventNode) bool
 
-	httpClient *http.Client
+	client *Client
 
 	// If this connection's mask specifies fields in a nested struct, then
 	// that struct will be non-nil for results. For example, if the mask
@@ -643,7 +644,17 @@ func (ec *EventsConnection) cursor() string {
 	return ec.cursors[n-1]
 }
 
-func (ec *EventsConnection) call() error {
+func (ec *EventsConnection) call() (err error) {
+	start := time.Now()
+	ctx, endSpan := ec.client.trace(ec.ctx, MetricGraphQLPage)
+	defer func() {
+		endSpan(err)
+		ec.client.observe(MetricGraphQLPage, start, err)
+	}()
+
+	if err := ec.client.rateLimit(ctx, MetricGraphQLPage, nil); err != nil {
+		return err
+	}
 	graphQLQuery, err := ec.mask.SearchOpQuery()
 	if err != nil {
 		return err
@@ -663,24 +674,23 @@ func (ec *EventsConnection) call() error {
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", ec.url, bytes.NewBuffer(encoded))
+	req, err := http.NewRequestWithContext(ctx, "POST", ec.url, bytes.NewBuffer(encoded))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", ec.authorization)
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := ec.httpClient.Do(req)
+	resp, err := ec.client.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		if body, err := io.ReadAll(resp.Body); err == nil {
-			log.Printf("GraphQL error response: %s", body)
+			ec.client.logger().DebugContext(req.Context(), "retraced: GraphQL error response", "status", resp.StatusCode, "body", string(body))
 		}
-		return fmt.Errorf("unexpected response from retraced api endpoint %s: %d", req.URL.String(), resp.StatusCode)
+		return &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode}
 	}
 
 	root := &graphQLSearchRoot{}
@@ -708,7 +718,14 @@ func (ec *EventsConnection) call() error {
 		if ec.mask.AnyDisplay() && event.Display == nil {
 			event.Display = &Display{}
 		}
-		events = append(events, edge.Node)
+		if ec.client.Encryptor != nil {
+			if err := ec.client.Encryptor.Decrypt(event); err != nil {
+				ec.client.logger().WarnContext(ctx, "retraced: event values left encrypted", "event_id", event.ID, "error", err)
+			}
+		}
+		if ec.keep == nil || ec.keep(event) {
+			events = append(events, edge.Node)
+		}
 
 		if root.Data.Search.PageInfo.HasPreviousPage && i == hits-1 {
 			ec.cursors = append(ec.cursors, edge.Cursor)
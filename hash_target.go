@@ -0,0 +1,199 @@
+package retraced
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// HashFormat selects the layout of the hash target that the Retraced API
+// hashes when an event is created.
+type HashFormat int
+
+const (
+	// HashFormatDefault selects the newest format supported by this SDK.
+	HashFormatDefault HashFormat = iota
+	// HashFormatV1 is the format used by the Retraced API since publisher v1.
+	HashFormatV1
+)
+
+// hashTargetBuilder holds the reusable buffers for building a hash target.
+type hashTargetBuilder struct {
+	buf  []byte
+	keys []string
+}
+
+func (b *hashTargetBuilder) Len() int           { return len(b.keys) }
+func (b *hashTargetBuilder) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b *hashTargetBuilder) Swap(i, j int)      { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+
+var hashTargetBuilders = sync.Pool{
+	New: func() interface{} {
+		return &hashTargetBuilder{
+			buf:  make([]byte, 0, 512),
+			keys: make([]string, 0, 16),
+		}
+	},
+}
+
+// AppendHashTarget appends the hash target of the event in the given format
+// to dst and returns the extended buffer.
+func (event *Event) AppendHashTarget(dst []byte, newEvent *NewEventRecord, format HashFormat) ([]byte, error) {
+	b := hashTargetBuilders.Get().(*hashTargetBuilder)
+	defer hashTargetBuilders.Put(b)
+
+	pooled := b.buf
+	b.buf = dst
+	err := b.build(event, newEvent, format)
+	dst = b.buf
+	b.buf = pooled[:0]
+	return dst, err
+}
+
+func (b *hashTargetBuilder) build(event *Event, newEvent *NewEventRecord, format HashFormat) error {
+	switch format {
+	case HashFormatDefault, HashFormatV1:
+		b.buildV1(event, newEvent)
+		return nil
+	default:
+		return fmt.Errorf("unsupported hash format %d", format)
+	}
+}
+
+func (b *hashTargetBuilder) buildV1(event *Event, newEvent *NewEventRecord) {
+	b.buf = appendEscaped(b.buf, newEvent.ID, false)
+	b.buf = append(b.buf, ':')
+	b.buf = appendEscaped(b.buf, event.Action, false)
+	b.buf = append(b.buf, ':')
+	if event.Target != nil {
+		b.buf = appendEscaped(b.buf, event.Target.ID, false)
+	}
+	b.buf = append(b.buf, ':')
+	if event.Actor != nil {
+		b.buf = appendEscaped(b.buf, event.Actor.ID, false)
+	}
+	b.buf = append(b.buf, ':')
+	if event.Group != nil {
+		b.buf = appendEscaped(b.buf, event.Group.ID, false)
+	}
+	b.buf = append(b.buf, ':')
+	b.buf = appendEscaped(b.buf, event.SourceIP, false)
+	b.buf = append(b.buf, ':')
+	b.buf = appendFlag(b.buf, event.IsFailure)
+	b.buf = appendFlag(b.buf, event.IsAnonymous)
+
+	if len(event.Fields) == 0 {
+		b.buf = append(b.buf, ':')
+	} else {
+		b.appendFields(event.Fields)
+	}
+
+	if event.ExternalID != "" {
+		b.buf = append(b.buf, ':')
+		b.buf = appendEscaped(b.buf, event.ExternalID, false)
+	}
+
+	if len(event.Metadata) > 0 {
+		b.buf = append(b.buf, ':')
+		b.appendFields(event.Metadata)
+	}
+}
+
+func (b *hashTargetBuilder) appendFields(fields Fields) {
+	b.keys = b.keys[:0]
+	for k := range fields {
+		b.keys = append(b.keys, k)
+	}
+	sort.Sort(b)
+	for _, k := range b.keys {
+		b.buf = appendEscaped(b.buf, k, true)
+		b.buf = append(b.buf, '=')
+		b.buf = appendEscaped(b.buf, fields[k], true)
+		b.buf = append(b.buf, ';')
+	}
+	// Don't keep the event's strings reachable from the pool.
+	for i := range b.keys {
+		b.keys[i] = ""
+	}
+}
+
+func appendFlag(dst []byte, flag bool) []byte {
+	if flag {
+		return append(dst, '1', ':')
+	}
+	return append(dst, '0', ':')
+}
+
+// appendEscaped percent-encodes the hash target delimiters in a single pass.
+// Escaping '%' and ':' matches encodePassOne, and the additional '=' and ';'
+// escapes for field keys and values match encodePassTwo applied after it.
+func appendEscaped(dst []byte, s string, fieldDelimiters bool) []byte {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		var esc string
+		switch s[i] {
+		case '%':
+			esc = "%25"
+		case ':':
+			esc = "%3A"
+		case '=':
+			if fieldDelimiters {
+				esc = "%3D"
+			}
+		case ';':
+			if fieldDelimiters {
+				esc = "%3B"
+			}
+		}
+		if esc == "" {
+			continue
+		}
+		dst = append(dst, s[start:i]...)
+		dst = append(dst, esc...)
+		start = i + 1
+	}
+	return append(dst, s[start:]...)
+}
+
+// HashMismatchError is returned when the hash computed locally for a reported
+// event does not match the hash returned by the Retraced API. The event has
+// already been stored by the API, so it should not be reported again.
+type HashMismatchError struct {
+	// Record is the record returned by the Retraced API for the stored event
+	Record *NewEventRecord
+	// LocalHash is the hex encoded hash computed by the SDK
+	LocalHash string
+	// HashTarget is the data the SDK hashed to compute LocalHash
+	HashTarget []byte
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("hash mismatch: local[%s] != remote[%s]", e.LocalHash, e.Record.Hash)
+}
+
+// verifyHash compares the hash of the event's target in the given format with
+// the hash returned by the Retraced API without allocating on success.
+func (event *Event) verifyHash(newEvent *NewEventRecord, format HashFormat) error {
+	b := hashTargetBuilders.Get().(*hashTargetBuilder)
+	defer hashTargetBuilders.Put(b)
+
+	b.buf = b.buf[:0]
+	if err := b.build(event, newEvent, format); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(b.buf)
+	var local [sha256.Size * 2]byte
+	hex.Encode(local[:], sum[:])
+	if string(local[:]) != newEvent.Hash {
+		return &HashMismatchError{
+			Record:     newEvent,
+			LocalHash:  string(local[:]),
+			HashTarget: append([]byte(nil), b.buf...),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package retraced
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hashTargetVector struct {
+	Name       string `json:"name"`
+	ID         string `json:"id"`
+	Event      *Event `json:"event"`
+	HashTarget string `json:"hash_target"`
+	Hash       string `json:"hash"`
+}
+
+func loadHashTargetVectors(t testing.TB) []hashTargetVector {
+	data, err := os.ReadFile("testdata/hash_target_vectors.json")
+	require.NoError(t, err)
+	var vectors []hashTargetVector
+	require.NoError(t, json.Unmarshal(data, &vectors))
+	return vectors
+}
+
+func TestHashTargetVectors(t *testing.T) {
+	for _, v := range loadHashTargetVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			record := &NewEventRecord{ID: v.ID, Hash: v.Hash}
+			assert.Equal(t, v.HashTarget, string(v.Event.BuildHashTarget(record)))
+			assert.NoError(t, v.Event.VerifyHash(record))
+
+			prefixed, err := v.Event.AppendHashTarget([]byte("prefix|"), record, HashFormatV1)
+			require.NoError(t, err)
+			assert.Equal(t, "prefix|"+v.HashTarget, string(prefixed))
+		})
+	}
+}
+
+func TestHashTargetUnsupportedFormat(t *testing.T) {
+	_, err := (&Event{Action: "a"}).AppendHashTarget(nil, &NewEventRecord{}, HashFormat(99))
+	assert.Error(t, err)
+}
+
+func TestVerifyHashAllocations(t *testing.T) {
+	v := loadHashTargetVectors(t)[1]
+	record := &NewEventRecord{ID: v.ID, Hash: v.Hash}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := v.Event.VerifyHash(record); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestAppendHashTargetKeepsPooledBuffer(t *testing.T) {
+	v := loadHashTargetVectors(t)[1]
+	record := &NewEventRecord{ID: v.ID, Hash: v.Hash}
+	dst := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		dst, _ = v.Event.AppendHashTarget(dst[:0], record, HashFormatV1)
+		if err := v.Event.VerifyHash(record); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// sync.Pool drops some items under the race detector, so allow for one
+	// new builder. Without a pooled buffer, VerifyHash grows a new one.
+	assert.Less(t, allocs, 2.0)
+}
+
+func BenchmarkVerifyHash(b *testing.B) {
+	v := loadHashTargetVectors(b)[1]
+	record := &NewEventRecord{ID: v.ID, Hash: v.Hash}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := v.Event.VerifyHash(record); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package retraced
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Receipt is the local record of an event accepted by the Retraced API.
+type Receipt struct {
+	// ID is the event id assigned by the Retraced API
+	ID string `json:"id"`
+
+	// Hash is the event hash returned by the Retraced API
+	Hash string `json:"hash"`
+
+	// Time is when the receipt was appended to the ledger
+	Time time.Time `json:"time"`
+}
+
+// leafData is the canonical serialization of a receipt that is hashed into
+// the ledger's Merkle tree.
+func (r *Receipt) leafData() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:", encodePassOne(r.ID))
+	fmt.Fprintf(&buf, "%s:", encodePassOne(r.Hash))
+	fmt.Fprintf(&buf, "%d", r.Time.UTC().UnixNano())
+	return buf.Bytes()
+}
+
+// Checkpoint is a signed statement of the ledger's Merkle root at a given size.
+type Checkpoint struct {
+	// Size is the number of receipts covered by Root
+	Size uint64 `json:"size"`
+
+	// Root is the Merkle tree hash of the first Size receipts
+	Root []byte `json:"root"`
+
+	// Time is when the checkpoint was created
+	Time time.Time `json:"time"`
+
+	// Signature is an ed25519 signature over the checkpoint's signed data
+	Signature []byte `json:"signature"`
+}
+
+// signedData is the message covered by the checkpoint signature.
+func (cp *Checkpoint) signedData() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("retraced-receipt-checkpoint:v1\n")
+	binary.Write(&buf, binary.BigEndian, cp.Size)
+	buf.Write(cp.Root)
+	binary.Write(&buf, binary.BigEndian, cp.Time.UTC().UnixNano())
+	return buf.Bytes()
+}
+
+// Verify checks the checkpoint signature against the ledger's public key.
+func (cp *Checkpoint) Verify(publicKey ed25519.PublicKey) error {
+	if !ed25519.Verify(publicKey, cp.signedData(), cp.Signature) {
+		return errors.New("checkpoint signature verification failed")
+	}
+	return nil
+}
+
+// ledgerEntry is a single line of the ledger file.
+type ledgerEntry struct {
+	Receipt    *Receipt    `json:"receipt,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// ReceiptLedger is an append-only, tamper-evident store of Receipts. Receipts
+// are the leaves of an RFC 6962 style Merkle tree, and the ledger periodically
+// signs checkpoints of the tree root so that auditors can later prove that no
+// receipt was dropped or rewritten.
+// It is safe for concurrent access.
+type ReceiptLedger struct {
+	// CheckpointEvery creates a signed checkpoint automatically after this
+	// many receipts have been appended since the last one. Zero disables
+	// automatic checkpoints.
+	CheckpointEvery int
+
+	signer      ed25519.PrivateKey
+	receipts    []*Receipt
+	leaves      [][]byte
+	checkpoints []*Checkpoint
+	file        *os.File
+	mtx         sync.Mutex
+}
+
+// NewReceiptLedger creates an in-memory ledger that signs checkpoints with signer.
+func NewReceiptLedger(signer ed25519.PrivateKey) *ReceiptLedger {
+	return &ReceiptLedger{
+		signer: signer,
+	}
+}
+
+// OpenReceiptLedger opens or creates a ledger file at path. Existing receipts
+// and checkpoints are replayed, and every checkpoint found is verified against
+// the replayed tree before new entries are appended.
+func OpenReceiptLedger(path string, signer ed25519.PrivateKey) (*ReceiptLedger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := NewReceiptLedger(signer)
+	if err := l.replay(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replaying receipt ledger %s: %v", path, err)
+	}
+	l.file = f
+
+	return l, nil
+}
+
+func (l *ReceiptLedger) replay(r io.Reader) error {
+	public := l.PublicKey()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return err
+		}
+		if entry.Receipt != nil {
+			l.receipts = append(l.receipts, entry.Receipt)
+			l.leaves = append(l.leaves, leafHash(entry.Receipt.leafData()))
+		}
+		if cp := entry.Checkpoint; cp != nil {
+			if cp.Size > uint64(len(l.leaves)) {
+				return fmt.Errorf("checkpoint size %d exceeds %d receipts", cp.Size, len(l.leaves))
+			}
+			if !bytes.Equal(cp.Root, rootHash(l.leaves[:cp.Size])) {
+				return fmt.Errorf("checkpoint at size %d does not match receipts", cp.Size)
+			}
+			if err := cp.Verify(public); err != nil {
+				return err
+			}
+			l.checkpoints = append(l.checkpoints, cp)
+		}
+	}
+	return scanner.Err()
+}
+
+// Close closes the ledger file, if any.
+func (l *ReceiptLedger) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// PublicKey returns the key that verifies this ledger's checkpoints.
+func (l *ReceiptLedger) PublicKey() ed25519.PublicKey {
+	return l.signer.Public().(ed25519.PublicKey)
+}
+
+// Append records a receipt for an event returned by ReportEvent and returns
+// its index in the ledger.
+func (l *ReceiptLedger) Append(record *NewEventRecord) (uint64, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	receipt := &Receipt{
+		ID:   record.ID,
+		Hash: record.Hash,
+		Time: time.Now().UTC(),
+	}
+	if err := l.write(&ledgerEntry{Receipt: receipt}); err != nil {
+		return 0, err
+	}
+	l.receipts = append(l.receipts, receipt)
+	l.leaves = append(l.leaves, leafHash(receipt.leafData()))
+	index := uint64(len(l.leaves) - 1)
+
+	if l.CheckpointEvery > 0 {
+		var last uint64
+		if n := len(l.checkpoints); n > 0 {
+			last = l.checkpoints[n-1].Size
+		}
+		if uint64(len(l.leaves))-last >= uint64(l.CheckpointEvery) {
+			if _, err := l.checkpoint(); err != nil {
+				return index, err
+			}
+		}
+	}
+
+	return index, nil
+}
+
+// Checkpoint signs and records the current Merkle root.
+func (l *ReceiptLedger) Checkpoint() (*Checkpoint, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.checkpoint()
+}
+
+func (l *ReceiptLedger) checkpoint() (*Checkpoint, error) {
+	cp := &Checkpoint{
+		Size: uint64(len(l.leaves)),
+		Root: rootHash(l.leaves),
+		Time: time.Now().UTC(),
+	}
+	cp.Signature = ed25519.Sign(l.signer, cp.signedData())
+	if err := l.write(&ledgerEntry{Checkpoint: cp}); err != nil {
+		return nil, err
+	}
+	l.checkpoints = append(l.checkpoints, cp)
+	return cp, nil
+}
+
+func (l *ReceiptLedger) write(entry *ledgerEntry) error {
+	if l.file == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	encoded = append(encoded, '\n')
+	if _, err := l.file.Write(encoded); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Size returns the number of receipts in the ledger.
+func (l *ReceiptLedger) Size() uint64 {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return uint64(len(l.leaves))
+}
+
+// Receipt returns the receipt at index.
+func (l *ReceiptLedger) Receipt(index uint64) (*Receipt, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if index >= uint64(len(l.receipts)) {
+		return nil, fmt.Errorf("receipt index %d out of range", index)
+	}
+	return l.receipts[index], nil
+}
+
+// Checkpoints returns all checkpoints recorded so far, oldest first.
+func (l *ReceiptLedger) Checkpoints() []*Checkpoint {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return append([]*Checkpoint(nil), l.checkpoints...)
+}
+
+// InclusionProof returns the audit path proving that the receipt at index is
+// included in the tree of the given size.
+func (l *ReceiptLedger) InclusionProof(index, size uint64) ([][]byte, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if size > uint64(len(l.leaves)) || index >= size {
+		return nil, fmt.Errorf("invalid inclusion proof request: index %d, size %d", index, size)
+	}
+	return inclusionPath(index, l.leaves[:size]), nil
+}
+
+// ConsistencyProof returns the proof that the tree of size newSize is an
+// append-only extension of the tree of size oldSize.
+func (l *ReceiptLedger) ConsistencyProof(oldSize, newSize uint64) ([][]byte, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if newSize > uint64(len(l.leaves)) || oldSize > newSize {
+		return nil, fmt.Errorf("invalid consistency proof request: %d to %d", oldSize, newSize)
+	}
+	if oldSize == 0 || oldSize == newSize {
+		return [][]byte{}, nil
+	}
+	return consistencySubproof(oldSize, l.leaves[:newSize], true), nil
+}
+
+// ReceiptLeafHash returns the Merkle leaf hash of a receipt, for use with
+// VerifyInclusion.
+func ReceiptLeafHash(r *Receipt) []byte {
+	return leafHash(r.leafData())
+}
+
+// VerifyInclusion checks an inclusion proof for leaf at index in a tree of
+// size with the given root.
+func VerifyInclusion(leaf []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return fmt.Errorf("index %d out of range for size %d", index, size)
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return errors.New("inclusion proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("inclusion proof too short")
+	}
+	if !bytes.Equal(r, root) {
+		return errors.New("inclusion proof does not match root")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size2 with root2 is an append-only
+// extension of the tree of size1 with root1.
+func VerifyConsistency(size1, size2 uint64, root1, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 > size2:
+		return fmt.Errorf("old size %d is larger than new size %d", size1, size2)
+	case size1 == size2:
+		if len(proof) != 0 {
+			return errors.New("consistency proof must be empty for equal sizes")
+		}
+		if !bytes.Equal(root1, root2) {
+			return errors.New("roots differ for equal sizes")
+		}
+		return nil
+	case size1 == 0:
+		if len(proof) != 0 {
+			return errors.New("consistency proof must be empty for an empty tree")
+		}
+		return nil
+	case len(proof) == 0:
+		return errors.New("empty consistency proof")
+	}
+
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("consistency proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("consistency proof too short")
+	}
+	if !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return errors.New("consistency proof does not match roots")
+	}
+	return nil
+}
+
+func leafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n.
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func rootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return nodeHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+func inclusionPath(m uint64, leaves [][]byte) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return [][]byte{}
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionPath(m, leaves[:k]), rootHash(leaves[k:]))
+	}
+	return append(inclusionPath(m-k, leaves[k:]), rootHash(leaves[:k]))
+}
+
+func consistencySubproof(m uint64, leaves [][]byte, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{rootHash(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(consistencySubproof(m, leaves[:k], complete), rootHash(leaves[k:]))
+	}
+	return append(consistencySubproof(m-k, leaves[k:], false), rootHash(leaves[:k]))
+}
@@ -0,0 +1,82 @@
+package retraced
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLedger(t *testing.T, n int) *ReceiptLedger {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	l := NewReceiptLedger(key)
+	for i := 0; i < n; i++ {
+		_, err := l.Append(&NewEventRecord{ID: fmt.Sprintf("event-%d", i), Hash: fmt.Sprintf("%064d", i)})
+		require.NoError(t, err)
+	}
+	return l
+}
+
+func TestLedgerInclusionProofs(t *testing.T) {
+	l := testLedger(t, 13)
+	for size := uint64(1); size <= l.Size(); size++ {
+		root := rootHash(l.leaves[:size])
+		for index := uint64(0); index < size; index++ {
+			proof, err := l.InclusionProof(index, size)
+			require.NoError(t, err)
+			receipt, err := l.Receipt(index)
+			require.NoError(t, err)
+			assert.NoError(t, VerifyInclusion(ReceiptLeafHash(receipt), index, size, proof, root), "index %d size %d", index, size)
+			assert.Error(t, VerifyInclusion(ReceiptLeafHash(receipt), index, size, proof, l.leaves[0][:31]), "index %d size %d", index, size)
+		}
+	}
+}
+
+func TestLedgerConsistencyProofs(t *testing.T) {
+	l := testLedger(t, 13)
+	for newSize := uint64(1); newSize <= l.Size(); newSize++ {
+		for oldSize := uint64(1); oldSize <= newSize; oldSize++ {
+			proof, err := l.ConsistencyProof(oldSize, newSize)
+			require.NoError(t, err)
+			oldRoot := rootHash(l.leaves[:oldSize])
+			newRoot := rootHash(l.leaves[:newSize])
+			assert.NoError(t, VerifyConsistency(oldSize, newSize, oldRoot, newRoot, proof), "%d to %d", oldSize, newSize)
+			if oldSize < newSize {
+				assert.Error(t, VerifyConsistency(oldSize, newSize, newRoot, newRoot, proof), "%d to %d", oldSize, newSize)
+			}
+		}
+	}
+}
+
+func TestLedgerReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.jsonl")
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	l, err := OpenReceiptLedger(path, key)
+	require.NoError(t, err)
+	l.CheckpointEvery = 4
+	for i := 0; i < 10; i++ {
+		_, err := l.Append(&NewEventRecord{ID: fmt.Sprintf("event-%d", i), Hash: "abc"})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	reopened, err := OpenReceiptLedger(path, key)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, uint64(10), reopened.Size())
+	checkpoints := reopened.Checkpoints()
+	require.Len(t, checkpoints, 2)
+	assert.Equal(t, uint64(8), checkpoints[1].Size)
+	assert.NoError(t, checkpoints[1].Verify(reopened.PublicKey()))
+
+	proof, err := reopened.ConsistencyProof(checkpoints[0].Size, checkpoints[1].Size)
+	require.NoError(t, err)
+	assert.NoError(t, VerifyConsistency(checkpoints[0].Size, checkpoints[1].Size, checkpoints[0].Root, checkpoints[1].Root, proof))
+}
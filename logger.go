@@ -0,0 +1,65 @@
+package retraced
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+)
+
+// Logger receives the SDK's internal diagnostics. It is implemented by
+// *slog.Logger, which is also the default: slog.Default() is used when no
+// Logger is configured.
+type Logger interface {
+	Enabled(ctx context.Context, level slog.Level) bool
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+func (c *Client) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+// reporterLogger returns the Logger of reporter if it is a Client.
+func reporterLogger(reporter EventReporter) Logger {
+	if c, ok := reporter.(*Client); ok {
+		return c.logger()
+	}
+	return slog.Default()
+}
+
+type secretBodiesKey struct{}
+
+// withSecretBodies marks the requests made with ctx as carrying credentials
+// in their request or response bodies, which are left out of debug dumps.
+func withSecretBodies(ctx context.Context) context.Context {
+	return context.WithValue(ctx, secretBodiesKey{}, true)
+}
+
+func hasSecretBodies(ctx context.Context) bool {
+	secret, _ := ctx.Value(secretBodiesKey{}).(bool)
+	return secret
+}
+
+// dumpRequest dumps a copy of req with the Authorization header redacted,
+// leaving the body of req unread. The body is left out of the dump unless
+// body is set.
+func dumpRequest(req *http.Request, body bool) ([]byte, error) {
+	redacted := req.Clone(req.Context())
+	if redacted.Header.Get("Authorization") != "" {
+		redacted.Header.Set("Authorization", "[REDACTED]")
+	}
+	body = body && req.GetBody != nil
+	if body {
+		var err error
+		if redacted.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return httputil.DumpRequestOut(redacted, body)
+}
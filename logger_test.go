@@ -0,0 +1,60 @@
+package retraced
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugDumpRedactsAuthorization(t *testing.T) {
+	var out bytes.Buffer
+	client := newCreatedServer(t, "", nil)
+	client.TokenProvider = StaticToken("super-secret-token")
+	client.Logger = slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := client.ReportEvent(&Event{Action: "a.test"})
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "a.test")
+	assert.Contains(t, out.String(), "[REDACTED]")
+	assert.NotContains(t, out.String(), "super-secret-token")
+}
+
+func TestDebugDumpOmitsTokenBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/viewertoken"):
+			w.Write([]byte(`{"token":"viewer-secret"}`))
+		case r.URL.Path == "/viewer/v1/viewersession":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":"session-secret"}`))
+		default:
+			w.Write([]byte(`{"data":{"search":{"totalCount":0,"edges":[]}}}`))
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, err := NewClient(server.URL, "dev", "dev")
+	require.NoError(t, err)
+	client.Logger = logger
+
+	token, err := client.GetViewerToken("g1", false, "u1", "")
+	require.NoError(t, err)
+	viewer, err := NewViewerClient(context.Background(), server.URL, "g1", token.Token, WithLogger(logger))
+	require.NoError(t, err)
+	_, err = viewer.Query(&StructuredQuery{}, &EventNodeMask{ID: true}, 10)
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "viewersession")
+	assert.NotContains(t, out.String(), "viewer-secret")
+	assert.NotContains(t, out.String(), "session-secret")
+}
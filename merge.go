@@ -0,0 +1,135 @@
+package retraced
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventReader returns a single event on every Read, and io.EOF after the last
+// one. It is implemented by Stream and MergedStream.
+type EventReader interface {
+	Read() (*EventNode, error)
+}
+
+// MergedStream reads from several EventReaders, each ordered by CanonicalTime
+// newest first like the Retraced API, and returns their events as a single
+// feed in the same order. Ties are broken by ID, and events with an ID that
+// was already returned are skipped. Duplicates are expected to share their
+// CanonicalTime, as they do when overlapping queries return the same event,
+// so only the IDs seen at the current CanonicalTime are kept.
+// It is safe for concurrent access.
+type MergedStream struct {
+	ctx      context.Context
+	readers  []EventReader
+	heap     mergeHeap
+	primed   bool
+	lastTime time.Time
+	seen     map[string]struct{}
+	err      error
+	mtx      sync.Mutex
+}
+
+// MergeStreams merges streams into a single chronological feed. Read returns
+// the error of ctx once it is done. Page requests already in flight are only
+// canceled if the streams use the same context, e.g. through
+// Client.NewStreamContext.
+func MergeStreams(ctx context.Context, streams ...EventReader) *MergedStream {
+	return &MergedStream{
+		ctx:     ctx,
+		readers: streams,
+		seen:    map[string]struct{}{},
+	}
+}
+
+// Read returns the next event, or io.EOF when all streams are exhausted. Once
+// a stream fails, Read keeps returning its error.
+func (m *MergedStream) Read() (*EventNode, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for m.err == nil {
+		if m.err = m.ctx.Err(); m.err != nil {
+			break
+		}
+		if !m.primed {
+			for i := range m.readers {
+				if m.err = m.push(i); m.err != nil {
+					return nil, m.err
+				}
+			}
+			m.primed = true
+		}
+		if m.heap.Len() == 0 {
+			return nil, io.EOF
+		}
+
+		item := heap.Pop(&m.heap).(mergeItem)
+		if m.err = m.push(item.reader); m.err != nil {
+			break
+		}
+
+		if !item.event.CanonicalTime.Equal(m.lastTime) {
+			m.lastTime = item.event.CanonicalTime
+			m.seen = map[string]struct{}{}
+		}
+		if _, ok := m.seen[item.event.ID]; ok {
+			continue
+		}
+		m.seen[item.event.ID] = struct{}{}
+		return item.event, nil
+	}
+	return nil, m.err
+}
+
+// push reads the next event of reader i onto the heap.
+func (m *MergedStream) push(i int) error {
+	event, err := m.readers[i].Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	heap.Push(&m.heap, mergeItem{event: event, reader: i})
+	return nil
+}
+
+// newerEvent orders events by CanonicalTime, newest first, then by ID.
+func newerEvent(a, b *EventNode) bool {
+	if !a.CanonicalTime.Equal(b.CanonicalTime) {
+		return a.CanonicalTime.After(b.CanonicalTime)
+	}
+	return a.ID < b.ID
+}
+
+type mergeItem struct {
+	event  *EventNode
+	reader int
+}
+
+// mergeHeap orders the next event of every reader, newest first.
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := h[i].event, h[j].event
+	if a.ID == b.ID && a.CanonicalTime.Equal(b.CanonicalTime) {
+		return h[i].reader < h[j].reader
+	}
+	return newerEvent(a, b)
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,79 @@
+package retraced
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sliceReader []*EventNode
+
+func (s *sliceReader) Read() (*EventNode, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	event := (*s)[0]
+	*s = (*s)[1:]
+	return event, nil
+}
+
+func readerOf(events ...*EventNode) *sliceReader {
+	s := sliceReader(events)
+	return &s
+}
+
+func readIDs(t *testing.T, r EventReader) []string {
+	var ids []string
+	for {
+		event, err := r.Read()
+		if err == io.EOF {
+			return ids
+		}
+		require.NoError(t, err)
+		ids = append(ids, event.ID)
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	now := time.Now()
+	at := func(id string, ago int) *EventNode {
+		return &EventNode{ID: id, CanonicalTime: now.Add(-time.Duration(ago) * time.Second)}
+	}
+
+	merged := MergeStreams(context.Background(),
+		readerOf(at("a", 1), at("d", 3), at("f", 5)),
+		readerOf(),
+		readerOf(at("b", 2), at("c", 3), at("e", 4)),
+		readerOf(at("d", 3), at("g", 6)),
+	)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, readIDs(t, merged))
+	_, err := merged.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+type failingReader struct{ err error }
+
+func (f failingReader) Read() (*EventNode, error) { return nil, f.err }
+
+func TestMergeStreamsErrors(t *testing.T) {
+	boom := errors.New("boom")
+	merged := MergeStreams(context.Background(), readerOf(&EventNode{ID: "a"}), failingReader{boom})
+	_, err := merged.Read()
+	assert.Equal(t, boom, err)
+	_, err = merged.Read()
+	assert.Equal(t, boom, err, "errors are sticky")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	merged = MergeStreams(ctx, readerOf(&EventNode{ID: "a"}, &EventNode{ID: "b"}))
+	event, err := merged.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "a", event.ID)
+	cancel()
+	_, err = merged.Read()
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,69 @@
+package retraced
+
+import (
+	"errors"
+	"time"
+)
+
+// Operations reported to Metrics.
+const (
+	MetricReportEvent          = "report_event"
+	MetricViewerToken          = "viewer_token"
+	MetricDeleteViewerSessions = "delete_viewer_sessions"
+	MetricGraphQLPage          = "graphql_page"
+	MetricHashVerification     = "hash_verification"
+	MetricAsyncReport          = "async_report"
+)
+
+// Statuses reported to Metrics.
+const (
+	MetricStatusOK       = "ok"
+	MetricStatusError    = "error"
+	MetricStatusMismatch = "mismatch"
+	MetricStatusRetry    = "retry"
+	MetricStatusDropped  = "dropped"
+)
+
+// MetricAsyncQueueDepth is the gauge holding the number of events queued by
+// an AsyncReporter.
+const MetricAsyncQueueDepth = "async_queue_depth"
+
+// Metrics collects counters and latency histograms for SDK operations, keyed
+// by operation and status, and gauges keyed by name.
+type Metrics interface {
+	// IncCounter counts one occurrence of operation with status
+	IncCounter(operation, status string)
+	// ObserveDuration records how long an occurrence of operation took
+	ObserveDuration(operation, status string, d time.Duration)
+	// SetGauge sets the current value of a gauge
+	SetGauge(name string, value float64)
+}
+
+// NopMetrics discards all metrics. It is the default.
+type NopMetrics struct{}
+
+func (NopMetrics) IncCounter(operation, status string)                       {}
+func (NopMetrics) ObserveDuration(operation, status string, d time.Duration) {}
+func (NopMetrics) SetGauge(name string, value float64)                       {}
+
+func (c *Client) metrics() Metrics {
+	if c.Metrics != nil {
+		return c.Metrics
+	}
+	return NopMetrics{}
+}
+
+// observe counts an operation that started at start and finished with err.
+func (c *Client) observe(operation string, start time.Time, err error) {
+	status := MetricStatusOK
+	if err != nil {
+		status = MetricStatusError
+		var mismatch *HashMismatchError
+		if errors.As(err, &mismatch) {
+			status = MetricStatusMismatch
+		}
+	}
+	m := c.metrics()
+	m.IncCounter(operation, status)
+	m.ObserveDuration(operation, status, time.Since(start))
+}
@@ -0,0 +1,65 @@
+package retraced
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// ExpvarMetrics publishes metrics with the expvar package. Counters appear in
+// the "counters" map keyed by "operation.status", durations in the
+// "duration_seconds" map as count and sum per "operation.status", and gauges
+// in the "gauges" map.
+type ExpvarMetrics struct {
+	counters  *expvar.Map
+	durations *expvar.Map
+	gauges    *expvar.Map
+	mtx       sync.Mutex
+}
+
+// NewExpvarMetrics publishes an expvar.Map with the given name. Like
+// expvar.Publish, it panics if the name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		counters:  new(expvar.Map).Init(),
+		durations: new(expvar.Map).Init(),
+		gauges:    new(expvar.Map).Init(),
+	}
+	root := expvar.NewMap(name)
+	root.Set("counters", m.counters)
+	root.Set("duration_seconds", m.durations)
+	root.Set("gauges", m.gauges)
+	return m
+}
+
+func (m *ExpvarMetrics) IncCounter(operation, status string) {
+	m.counters.Add(operation+"."+status, 1)
+}
+
+func (m *ExpvarMetrics) ObserveDuration(operation, status string, d time.Duration) {
+	key := operation + "." + status
+	summary, ok := m.durations.Get(key).(*expvar.Map)
+	if !ok {
+		m.mtx.Lock()
+		if summary, ok = m.durations.Get(key).(*expvar.Map); !ok {
+			summary = new(expvar.Map).Init()
+			m.durations.Set(key, summary)
+		}
+		m.mtx.Unlock()
+	}
+	summary.Add("count", 1)
+	summary.AddFloat("sum", d.Seconds())
+}
+
+func (m *ExpvarMetrics) SetGauge(name string, value float64) {
+	gauge, ok := m.gauges.Get(name).(*expvar.Float)
+	if !ok {
+		m.mtx.Lock()
+		if gauge, ok = m.gauges.Get(name).(*expvar.Float); !ok {
+			gauge = new(expvar.Float)
+			m.gauges.Set(name, gauge)
+		}
+		m.mtx.Unlock()
+	}
+	gauge.Set(value)
+}
@@ -0,0 +1,151 @@
+package retraced
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the histogram buckets, in seconds, used by
+// PrometheusMetrics when none are configured.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricKey struct {
+	operation, status string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// PrometheusMetrics collects metrics in memory and serves them in the
+// Prometheus text exposition format. Counters are exported as
+// retraced_operations_total, durations as the
+// retraced_operation_duration_seconds histogram and gauges as
+// retraced_<name>.
+type PrometheusMetrics struct {
+	buckets    []float64
+	counters   map[metricKey]uint64
+	histograms map[metricKey]*histogram
+	gauges     map[string]float64
+	mtx        sync.Mutex
+}
+
+// NewPrometheusMetrics creates PrometheusMetrics with the given histogram
+// buckets in seconds, or DefaultDurationBuckets if none are given.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusMetrics{
+		buckets:    buckets,
+		counters:   make(map[metricKey]uint64),
+		histograms: make(map[metricKey]*histogram),
+		gauges:     make(map[string]float64),
+	}
+}
+
+func (m *PrometheusMetrics) IncCounter(operation, status string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.counters[metricKey{operation, status}]++
+}
+
+func (m *PrometheusMetrics) ObserveDuration(operation, status string, d time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key := metricKey{operation, status}
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *PrometheusMetrics) SetGauge(name string, value float64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.gauges[name] = value
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(m.String()))
+}
+
+// String returns all metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) String() string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var b strings.Builder
+
+	b.WriteString("# HELP retraced_operations_total Retraced SDK operations by outcome.\n")
+	b.WriteString("# TYPE retraced_operations_total counter\n")
+	for _, key := range sortedMetricKeys(m.counters) {
+		fmt.Fprintf(&b, "retraced_operations_total{%s} %d\n", key.labels(), m.counters[key])
+	}
+
+	b.WriteString("# HELP retraced_operation_duration_seconds Retraced SDK operation latency.\n")
+	b.WriteString("# TYPE retraced_operation_duration_seconds histogram\n")
+	for _, key := range sortedMetricKeys(m.histograms) {
+		h := m.histograms[key]
+		labels := key.labels()
+		for i, bound := range m.buckets {
+			fmt.Fprintf(&b, "retraced_operation_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "retraced_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "retraced_operation_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(&b, "retraced_operation_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	names := make([]string, 0, len(m.gauges))
+	for name := range m.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metric := "retraced_" + invalidMetricChars.ReplaceAllString(name, "_")
+		fmt.Fprintf(&b, "# TYPE %s gauge\n%s %g\n", metric, metric, m.gauges[name])
+	}
+
+	return b.String()
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (k metricKey) labels() string {
+	return fmt.Sprintf(`operation="%s",status="%s"`, labelEscaper.Replace(k.operation), labelEscaper.Replace(k.status))
+}
+
+func sortedMetricKeys[V any](m map[metricKey]V) []metricKey {
+	keys := make([]metricKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].status < keys[j].status
+	})
+	return keys
+}
@@ -0,0 +1,32 @@
+package retraced
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics(0.5, 1)
+	client := newCreatedServer(t, "bad", nil)
+	client.Metrics = metrics
+
+	_, err := client.ReportEvent(&Event{Action: "a.test"})
+	require.Error(t, err)
+	metrics.ObserveDuration("custom", "ok", 750*time.Millisecond)
+	metrics.SetGauge(MetricAsyncQueueDepth, 3)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(t, body, `retraced_operations_total{operation="report_event",status="mismatch"} 1`)
+	assert.Contains(t, body, `retraced_operations_total{operation="hash_verification",status="mismatch"} 1`)
+	assert.Contains(t, body, `retraced_operation_duration_seconds_bucket{operation="custom",status="ok",le="0.5"} 0`)
+	assert.Contains(t, body, `retraced_operation_duration_seconds_bucket{operation="custom",status="ok",le="1"} 1`)
+	assert.Contains(t, body, `retraced_operation_duration_seconds_count{operation="custom",status="ok"} 1`)
+	assert.Contains(t, body, "retraced_async_queue_depth 3")
+}
@@ -0,0 +1,263 @@
+package retraced
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// AuditMiddleware reports an Event for every request handled by the wrapped
+// handler. Handlers can add fields to the event or suppress it through the
+// request context with SetAuditField, AuditEventFromContext and SuppressAudit.
+// The request's Actor, Group and SourceIP are also added to the request
+// context, so ReportEventContext can use them deeper in the call stack.
+type AuditMiddleware struct {
+	// Reporter reports the events, e.g. a Client or an AsyncReporter
+	Reporter EventReporter
+
+	// Action derives the event Action from the request, default is DefaultAuditAction
+	Action func(r *http.Request) string
+
+	// Principal returns the Actor and Group that made the request. A nil Actor
+	// reports the event as anonymous.
+	Principal func(r *http.Request) (*Actor, *Group, error)
+
+	// TrustedProxies lists the proxies whose forwarding header is honored
+	// when deriving SourceIP, see SourceIPFromRequest
+	TrustedProxies TrustedProxies
+
+	// Skip returns true for requests that should not be audited. The default
+	// skips GET, HEAD and OPTIONS requests.
+	Skip func(r *http.Request) bool
+
+	// OnError is called when the principal can't be determined or the event
+	// fails to report. The default logs the error.
+	OnError func(r *http.Request, err error)
+
+	// Logger receives errors when OnError is not set, default is the Logger of
+	// the Reporter if it is a Client, else slog.Default()
+	Logger Logger
+}
+
+type auditContextKey struct{}
+
+// auditState is the in-flight event of a request.
+type auditState struct {
+	event    *Event
+	suppress bool
+	mtx      sync.Mutex
+}
+
+func auditStateFromContext(ctx context.Context) *auditState {
+	state, _ := ctx.Value(auditContextKey{}).(*auditState)
+	return state
+}
+
+// AuditEventFromContext returns the event that AuditMiddleware will report for
+// the request, or nil outside of an audited request. The event must not be
+// modified concurrently.
+func AuditEventFromContext(ctx context.Context) *Event {
+	if state := auditStateFromContext(ctx); state != nil {
+		return state.event
+	}
+	return nil
+}
+
+// SetAuditField sets a field on the request's audit event. It is a no-op
+// outside of an audited request.
+func SetAuditField(ctx context.Context, key, value string) {
+	state := auditStateFromContext(ctx)
+	if state == nil {
+		return
+	}
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	if state.event.Fields == nil {
+		state.event.Fields = Fields{}
+	}
+	state.event.Fields[key] = value
+}
+
+// SuppressAudit prevents the request's audit event from being reported.
+func SuppressAudit(ctx context.Context) {
+	state := auditStateFromContext(ctx)
+	if state == nil {
+		return
+	}
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	state.suppress = true
+}
+
+// CRUDFromMethod maps an HTTP method to the Event CRUD verb.
+func CRUDFromMethod(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "c"
+	case http.MethodPut, http.MethodPatch:
+		return "u"
+	case http.MethodDelete:
+		return "d"
+	default:
+		return "r"
+	}
+}
+
+var crudActions = map[string]string{
+	"c": "create",
+	"r": "read",
+	"u": "update",
+	"d": "delete",
+}
+
+// DefaultAuditAction derives an action from the request path and method,
+// skipping path segments that contain digits, which are usually ids. For
+// example "DELETE /users/42/keys/7" becomes "users.keys.delete".
+func DefaultAuditAction(r *http.Request) string {
+	var parts []string
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if segment == "" || strings.IndexFunc(segment, unicode.IsDigit) >= 0 {
+			continue
+		}
+		parts = append(parts, strings.ToLower(segment))
+	}
+	parts = append(parts, crudActions[CRUDFromMethod(r.Method)])
+	return strings.Join(parts, ".")
+}
+
+func skipReadRequests(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// Handler wraps next so that each request is audited after it is served.
+func (m *AuditMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		skip := m.Skip
+		if skip == nil {
+			skip = skipReadRequests
+		}
+		if skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		actor, group, err := m.principal(r)
+		if err != nil {
+			m.onError(r, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		action := DefaultAuditAction
+		if m.Action != nil {
+			action = m.Action
+		}
+		state := &auditState{
+			event: &Event{
+				Action:      action(r),
+				Group:       group,
+				Actor:       actor,
+				Created:     time.Now().UTC(),
+				CRUD:        CRUDFromMethod(r.Method),
+				SourceIP:    SourceIPFromRequest(r, m.TrustedProxies),
+				IsAnonymous: actor == nil,
+			},
+		}
+
+		ctx := context.WithValue(r.Context(), auditContextKey{}, state)
+		if actor != nil {
+			ctx = WithActor(ctx, actor)
+		}
+		if group != nil {
+			ctx = WithGroup(ctx, group)
+		}
+		ctx = WithSourceIP(ctx, state.event.SourceIP)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		// Report a copy, so that handlers that still set fields don't
+		// wait on or race with the report.
+		state.mtx.Lock()
+		if state.suppress {
+			state.mtx.Unlock()
+			return
+		}
+		state.event.IsFailure = recorder.status() >= 400
+		event := *state.event
+		if event.Fields != nil {
+			event.Fields = withDefaults(event.Fields, nil)
+		}
+		state.mtx.Unlock()
+
+		// The request may have made its changes even if the client went
+		// away, so its event is reported regardless.
+		if _, err := reportEventContext(context.WithoutCancel(r.Context()), m.Reporter, &event); err != nil {
+			m.onError(r, err)
+		}
+	})
+}
+
+func (m *AuditMiddleware) principal(r *http.Request) (*Actor, *Group, error) {
+	if m.Principal == nil {
+		return nil, nil, nil
+	}
+	return m.Principal(r)
+}
+
+func (m *AuditMiddleware) onError(r *http.Request, err error) {
+	if m.OnError != nil {
+		m.OnError(r, err)
+		return
+	}
+	logger := m.Logger
+	if logger == nil {
+		logger = reporterLogger(m.Reporter)
+	}
+	logger.ErrorContext(r.Context(), "retraced: failed to audit request", "method", r.Method, "path", r.URL.Path, "error", err)
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) status() int {
+	if s.code == 0 {
+		return http.StatusOK
+	}
+	return s.code
+}
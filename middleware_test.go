@@ -0,0 +1,102 @@
+package retraced
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingReporter struct {
+	events []*Event
+	mtx    sync.Mutex
+}
+
+func (r *recordingReporter) ReportEvent(event *Event) (*NewEventRecord, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, event)
+	return &NewEventRecord{ID: "id"}, nil
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+	reporter := &recordingReporter{}
+	m := &AuditMiddleware{
+		Reporter: reporter,
+		Principal: func(r *http.Request) (*Actor, *Group, error) {
+			return &Actor{ID: "u1"}, &Group{ID: "g1"}, nil
+		},
+		TrustedProxies: proxies,
+	}
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("quiet") != "" {
+			SuppressAudit(r.Context())
+		}
+		SetAuditField(r.Context(), "reason", "cleanup")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/users/42/keys/7", nil)
+	req.RemoteAddr = "10.1.2.3:5555"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7, 10.0.0.2")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users?quiet=1", nil))
+
+	require.Len(t, reporter.events, 1)
+	event := reporter.events[0]
+	assert.Equal(t, "users.keys.delete", event.Action)
+	assert.Equal(t, "d", event.CRUD)
+	assert.Equal(t, "198.51.100.7", event.SourceIP)
+	assert.Equal(t, "u1", event.Actor.ID)
+	assert.Equal(t, "g1", event.Group.ID)
+	assert.True(t, event.IsFailure)
+	assert.Equal(t, Fields{"reason": "cleanup"}, event.Fields)
+}
+
+type contextReporter struct {
+	recordingReporter
+	ctx context.Context
+}
+
+func (r *contextReporter) ReportEventContext(ctx context.Context, event *Event) (*NewEventRecord, error) {
+	r.ctx = ctx
+	return r.ReportEvent(event)
+}
+
+func TestAuditMiddlewareReportsWithContext(t *testing.T) {
+	reporter := &contextReporter{}
+	m := &AuditMiddleware{Reporter: reporter}
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The client disconnects before the handler completes.
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), requestIDKey{}, "req-1"))
+	cancel()
+	req := httptest.NewRequest(http.MethodDelete, "/users/1", nil).WithContext(ctx)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, reporter.events, 1)
+	require.NotNil(t, reporter.ctx)
+	assert.Equal(t, "req-1", reporter.ctx.Value(requestIDKey{}))
+	assert.NoError(t, reporter.ctx.Err(), "the event is reported after the client went away")
+}
+
+func TestAsyncReporter(t *testing.T) {
+	reporter := &recordingReporter{}
+	async := NewAsyncReporter(reporter, 10, 2)
+	for i := 0; i < 5; i++ {
+		_, err := async.ReportEvent(&Event{Action: "a"})
+		require.NoError(t, err)
+	}
+	async.Close()
+	assert.Len(t, reporter.events, 5)
+	_, err := async.ReportEvent(&Event{Action: "a"})
+	assert.Equal(t, ErrReporterClosed, err)
+}
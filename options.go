@@ -0,0 +1,307 @@
+package retraced
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option configures a Client created with New.
+type Option func(c *Client) error
+
+// New creates a new retraced api client for the project, configured by opts.
+// Options are applied in order, except WithTimeout, which is applied last.
+func New(projectID string, apiToken string, opts ...Option) (*Client, error) {
+	c := &Client{
+		projectID:  projectID,
+		token:      apiToken,
+		Endpoint:   defaultEndpoint,
+		HttpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.timeout != nil {
+		httpClient := *c.HttpClient
+		httpClient.Timeout = *c.timeout
+		c.HttpClient = &httpClient
+		c.timeout = nil
+	}
+	return c, nil
+}
+
+// WithEndpoint sets the retraced api base url.
+func WithEndpoint(endpoint string) Option {
+	return func(c *Client) error {
+		ep, err := parseEndpoint(endpoint)
+		if err != nil {
+			return err
+		}
+		c.Endpoint = ep
+		return nil
+	}
+}
+
+// withOptionalEndpoint sets the endpoint unless it is empty, without the
+// validation of WithEndpoint, for the older constructors.
+func withOptionalEndpoint(endpoint string) Option {
+	return func(c *Client) error {
+		if endpoint != "" {
+			c.Endpoint = endpoint
+		}
+		return nil
+	}
+}
+
+// WithEndpoints sets an ordered list of base urls to fail over between, see
+// Client.Endpoints.
+func WithEndpoints(endpoints ...string) Option {
+	return func(c *Client) error {
+		if len(endpoints) == 0 {
+			return fmt.Errorf("retraced: no endpoints")
+		}
+		c.Endpoints = make([]string, len(endpoints))
+		for i, endpoint := range endpoints {
+			ep, err := parseEndpoint(endpoint)
+			if err != nil {
+				return err
+			}
+			c.Endpoints[i] = ep
+		}
+		c.Endpoint = c.Endpoints[0]
+		return nil
+	}
+}
+
+func parseEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("retraced: invalid endpoint %q: %v", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("retraced: invalid endpoint %q: must be an http or https url", endpoint)
+	}
+	return strings.TrimSuffix(endpoint, "/"), nil
+}
+
+// WithHTTPClient sets the http client used for API requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return fmt.Errorf("retraced: nil http client")
+		}
+		c.HttpClient = httpClient
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of API requests on a copy of the client's http
+// client, also when WithHTTPClient comes after it.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return fmt.Errorf("retraced: negative timeout %s", timeout)
+		}
+		c.timeout = &timeout
+		return nil
+	}
+}
+
+// WithComponent sets the Component of reported events.
+func WithComponent(component string) Option {
+	return func(c *Client) error {
+		c.Component = component
+		return nil
+	}
+}
+
+// WithVersion sets the Version of reported events.
+func WithVersion(version string) Option {
+	return func(c *Client) error {
+		c.Version = version
+		return nil
+	}
+}
+
+// WithViewLogAction sets the action logged when a Viewer Token is used.
+func WithViewLogAction(action string) Option {
+	return func(c *Client) error {
+		c.ViewLogAction = action
+		return nil
+	}
+}
+
+// WithRetries retries failed requests up to maxRetries times, with
+// exponential backoff starting at backoff, or the default if it is 0, see
+// Client.MaxRetries.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) error {
+		if maxRetries < 0 {
+			return fmt.Errorf("retraced: negative retries %d", maxRetries)
+		}
+		c.MaxRetries = maxRetries
+		c.RetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithTokenProvider sets the TokenProvider that supplies the API token of
+// every request, in which case the token passed to New may be empty.
+func WithTokenProvider(provider TokenProvider) Option {
+	return func(c *Client) error {
+		c.TokenProvider = provider
+		return nil
+	}
+}
+
+// WithLogger sets the Logger that receives the SDK's diagnostics.
+func WithLogger(logger Logger) Option {
+	return func(c *Client) error {
+		c.Logger = logger
+		return nil
+	}
+}
+
+// WithMetrics sets the Metrics that collects API operation metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Client) error {
+		c.Metrics = metrics
+		return nil
+	}
+}
+
+// WithTracer sets the Tracer called around API operations.
+func WithTracer(tracer Tracer) Option {
+	return func(c *Client) error {
+		c.Tracer = tracer
+		return nil
+	}
+}
+
+// WithRateLimiter sets the RateLimiter of ReportEvent and GraphQL requests.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(c *Client) error {
+		c.RateLimiter = limiter
+		return nil
+	}
+}
+
+// WithCircuitBreaker sets the CircuitBreaker of API requests.
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return func(c *Client) error {
+		c.CircuitBreaker = breaker
+		return nil
+	}
+}
+
+// WithEnrichers appends Enrichers that run before every event is reported.
+func WithEnrichers(enrichers ...Enricher) Option {
+	return func(c *Client) error {
+		c.Enrichers = append(c.Enrichers, enrichers...)
+		return nil
+	}
+}
+
+// WithHashVerification sets how hash mismatches are reported.
+func WithHashVerification(policy HashVerificationPolicy) Option {
+	return func(c *Client) error {
+		c.HashVerification = policy
+		return nil
+	}
+}
+
+// Environment variables read by NewClientFromEnv.
+const (
+	EnvProjectID     = "RETRACED_PROJECT_ID"
+	EnvAPIToken      = "RETRACED_API_TOKEN"
+	EnvEndpoint      = "RETRACED_ENDPOINT"
+	EnvComponent     = "RETRACED_COMPONENT"
+	EnvVersion       = "RETRACED_VERSION"
+	EnvViewLogAction = "RETRACED_VIEW_LOG_ACTION"
+	EnvTimeout       = "RETRACED_TIMEOUT"
+	EnvMaxRetries    = "RETRACED_MAX_RETRIES"
+)
+
+// NewClientFromEnv creates a client configured by environment variables.
+// RETRACED_PROJECT_ID and RETRACED_API_TOKEN are required. RETRACED_ENDPOINT
+// may hold a comma-separated list of endpoints to fail over between, and
+// RETRACED_TIMEOUT a duration such as "10s". RETRACED_COMPONENT,
+// RETRACED_VERSION, RETRACED_VIEW_LOG_ACTION and RETRACED_MAX_RETRIES are
+// optional. opts are applied after the environment.
+func NewClientFromEnv(opts ...Option) (*Client, error) {
+	projectID, err := requiredEnv(EnvProjectID)
+	if err != nil {
+		return nil, err
+	}
+	token, err := requiredEnv(EnvAPIToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var envOpts []Option
+	if value := os.Getenv(EnvEndpoint); value != "" {
+		var endpoints []string
+		for _, endpoint := range strings.Split(value, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		if len(endpoints) == 1 {
+			envOpts = append(envOpts, WithEndpoint(endpoints[0]))
+		} else {
+			envOpts = append(envOpts, WithEndpoints(endpoints...))
+		}
+	}
+	if value, ok := os.LookupEnv(EnvComponent); ok {
+		envOpts = append(envOpts, WithComponent(value))
+	}
+	if value, ok := os.LookupEnv(EnvVersion); ok {
+		envOpts = append(envOpts, WithVersion(value))
+	}
+	if value, ok := os.LookupEnv(EnvViewLogAction); ok {
+		envOpts = append(envOpts, WithViewLogAction(value))
+	}
+	if value := os.Getenv(EnvTimeout); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("retraced: %s: invalid duration %q", EnvTimeout, value)
+		}
+		envOpts = append(envOpts, WithTimeout(timeout))
+	}
+	if value := os.Getenv(EnvMaxRetries); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("retraced: %s: invalid number of retries %q", EnvMaxRetries, value)
+		}
+		envOpts = append(envOpts, WithRetries(retries, 0))
+	}
+
+	for i, opt := range envOpts {
+		envOpts[i] = fromEnv(opt)
+	}
+	return New(projectID, token, append(envOpts, opts...)...)
+}
+
+// fromEnv labels the errors of an option built from the environment.
+func fromEnv(opt Option) Option {
+	return func(c *Client) error {
+		if err := opt(c); err != nil {
+			return fmt.Errorf("%w (from environment)", err)
+		}
+		return nil
+	}
+}
+
+func requiredEnv(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", fmt.Errorf("retraced: %s is not set", name)
+	}
+	return value, nil
+}
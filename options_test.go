@@ -0,0 +1,101 @@
+package retraced
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	httpClient := &http.Client{}
+	client, err := New("p", "t",
+		WithEndpoint("https://retraced.example.com/auditlog/"),
+		WithHTTPClient(httpClient),
+		WithTimeout(5*time.Second),
+		WithComponent("web"),
+		WithVersion("1.0"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "https://retraced.example.com/auditlog", client.Endpoint)
+	assert.Equal(t, 5*time.Second, client.HttpClient.Timeout)
+	assert.Zero(t, httpClient.Timeout, "the given http client is not modified")
+	assert.Equal(t, "web", client.Component)
+	assert.Equal(t, "1.0", client.Version)
+
+	_, err = New("p", "t", WithEndpoint("retraced.example.com"))
+	assert.ErrorContains(t, err, "must be an http or https url")
+
+	client, err = NewClient("", "p", "t")
+	require.NoError(t, err)
+	assert.Equal(t, defaultEndpoint, client.Endpoint)
+	assert.Equal(t, http.DefaultClient, client.HttpClient)
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	t.Setenv(EnvProjectID, "")
+	_, err := NewClientFromEnv()
+	assert.EqualError(t, err, "retraced: RETRACED_PROJECT_ID is not set")
+
+	t.Setenv(EnvProjectID, "p")
+	t.Setenv(EnvAPIToken, "t")
+	t.Setenv(EnvEndpoint, "https://east.example.com, https://west.example.com")
+	t.Setenv(EnvComponent, "web")
+	t.Setenv(EnvTimeout, "3s")
+	t.Setenv(EnvMaxRetries, "2")
+	client, err := NewClientFromEnv(WithVersion("1.0"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://east.example.com", "https://west.example.com"}, client.Endpoints)
+	assert.Equal(t, "web", client.Component)
+	assert.Equal(t, "1.0", client.Version)
+	assert.Equal(t, 3*time.Second, client.HttpClient.Timeout)
+	assert.Equal(t, 2, client.MaxRetries)
+
+	// The timeout from the environment applies to a caller's http client.
+	httpClient := &http.Client{}
+	client, err = NewClientFromEnv(WithHTTPClient(httpClient))
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, client.HttpClient.Timeout)
+	assert.Zero(t, httpClient.Timeout, "the given http client is not modified")
+
+	// Only errors of the environment are labelled as such.
+	_, err = NewClientFromEnv(WithEndpoint("east"))
+	assert.EqualError(t, err, `retraced: invalid endpoint "east": must be an http or https url`)
+	t.Setenv(EnvEndpoint, "east")
+	_, err = NewClientFromEnv()
+	assert.EqualError(t, err, `retraced: invalid endpoint "east": must be an http or https url (from environment)`)
+
+	t.Setenv(EnvTimeout, "soon")
+	_, err = NewClientFromEnv()
+	assert.EqualError(t, err, `retraced: RETRACED_TIMEOUT: invalid duration "soon"`)
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"abc"}`))
+	}))
+	defer server.Close()
+
+	client, err := New("p", "t", WithEndpoint(server.URL), WithRetries(1, time.Millisecond), WithHashVerification(HashVerificationOff))
+	require.NoError(t, err)
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	client.MaxRetries = 2
+	atomic.StoreInt32(&calls, 0)
+	record, err := client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", record.ID)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,138 @@
+package retraced
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrUnknownPseudonym is returned when a pseudonym cannot be re-identified.
+var ErrUnknownPseudonym = errors.New("unknown pseudonym")
+
+// ReidentificationStore maps pseudonyms back to the identifiers they replace.
+type ReidentificationStore interface {
+	// Put records that pseudonym stands for identifier
+	Put(pseudonym, identifier string) error
+	// Get returns the identifier for pseudonym, or ErrUnknownPseudonym
+	Get(pseudonym string) (string, error)
+}
+
+// MemoryReidentificationStore is an in-memory ReidentificationStore.
+// It is safe for concurrent access.
+type MemoryReidentificationStore struct {
+	identifiers map[string]string
+	mtx         sync.RWMutex
+}
+
+func (s *MemoryReidentificationStore) Put(pseudonym, identifier string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.identifiers == nil {
+		s.identifiers = make(map[string]string)
+	}
+	s.identifiers[pseudonym] = identifier
+	return nil
+}
+
+func (s *MemoryReidentificationStore) Get(pseudonym string) (string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	identifier, ok := s.identifiers[pseudonym]
+	if !ok {
+		return "", ErrUnknownPseudonym
+	}
+	return identifier, nil
+}
+
+// Pseudonymizer replaces actor, and optionally target, identifiers with stable
+// keyed pseudonyms before events are reported. The same identifier always
+// maps to the same pseudonym under a given Key, so events can still be
+// searched by pseudonymized ActorID.
+type Pseudonymizer struct {
+	// Key is the HMAC-SHA256 secret; keep it out of the audit log's reach
+	Key []byte
+	// Prefix is prepended to every pseudonym, e.g. "psn_"
+	Prefix string
+	// Targets also pseudonymizes Target.ID and Target.Name
+	Targets bool
+	// Store, when set, records every pseudonym so it can be re-identified
+	Store ReidentificationStore
+}
+
+// Pseudonym returns the pseudonym for an identifier of the given kind, such
+// as "actor.id". Kinds keep equal values of different fields unlinkable.
+func (p *Pseudonymizer) Pseudonym(kind, identifier string) string {
+	mac := hmac.New(sha256.New, p.Key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(identifier))
+	return p.Prefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Pseudonymize rewrites the event's Actor, and Target if enabled. The Actor and
+// Target are replaced rather than modified.
+func (p *Pseudonymizer) Pseudonymize(event *Event) error {
+	if event.Actor != nil {
+		actor := *event.Actor
+		var err error
+		if actor.ID, err = p.rewrite("actor.id", actor.ID); err != nil {
+			return err
+		}
+		if actor.Name, err = p.rewrite("actor.name", actor.Name); err != nil {
+			return err
+		}
+		event.Actor = &actor
+	}
+
+	if p.Targets && event.Target != nil {
+		target := *event.Target
+		var err error
+		if target.ID, err = p.rewrite("target.id", target.ID); err != nil {
+			return err
+		}
+		if target.Name, err = p.rewrite("target.name", target.Name); err != nil {
+			return err
+		}
+		event.Target = &target
+	}
+
+	return nil
+}
+
+func (p *Pseudonymizer) rewrite(kind, identifier string) (string, error) {
+	if identifier == "" {
+		return "", nil
+	}
+	pseudonym := p.Pseudonym(kind, identifier)
+	if p.Store != nil {
+		if err := p.Store.Put(pseudonym, identifier); err != nil {
+			return "", err
+		}
+	}
+	return pseudonym, nil
+}
+
+// PseudonymizeQuery returns a copy of sq with ActorID and ActorName replaced by
+// their pseudonyms, so searches match pseudonymized events. Wildcards are not
+// supported, because pseudonyms do not preserve prefixes.
+func (p *Pseudonymizer) PseudonymizeQuery(sq *StructuredQuery) *StructuredQuery {
+	rewritten := *sq
+	if sq.ActorID != "" {
+		rewritten.ActorID = p.Pseudonym("actor.id", sq.ActorID)
+	}
+	if sq.ActorName != "" {
+		rewritten.ActorName = p.Pseudonym("actor.name", sq.ActorName)
+	}
+	return &rewritten
+}
+
+// Reidentify returns the identifier a pseudonym stands for. It is meant for
+// authorized tooling only and requires a Store.
+func (p *Pseudonymizer) Reidentify(pseudonym string) (string, error) {
+	if p.Store == nil {
+		return "", errors.New("re-identification requires a ReidentificationStore")
+	}
+	return p.Store.Get(pseudonym)
+}
@@ -0,0 +1,30 @@
+package retraced
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPseudonymize(t *testing.T) {
+	p := &Pseudonymizer{Key: []byte("secret"), Prefix: "psn_", Store: &MemoryReidentificationStore{}}
+	actor := &Actor{ID: "user-1", Name: "alice@example.com"}
+	event := &Event{Action: "login", Actor: actor, Target: &Target{ID: "doc-1"}}
+	require.NoError(t, p.Pseudonymize(event))
+
+	assert.Equal(t, "user-1", actor.ID, "caller's actor must not be modified")
+	assert.Regexp(t, `^psn_[0-9a-f]{32}$`, event.Actor.ID)
+	assert.NotEqual(t, event.Actor.ID, p.Pseudonym("actor.name", "user-1"))
+	assert.Equal(t, "doc-1", event.Target.ID)
+
+	sq := p.PseudonymizeQuery(&StructuredQuery{ActorID: "user-1", Action: "login"})
+	assert.Equal(t, event.Actor.ID, sq.ActorID)
+	assert.Equal(t, "login", sq.Action)
+
+	identifier, err := p.Reidentify(event.Actor.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", identifier)
+	_, err = p.Reidentify("psn_unknown")
+	assert.Equal(t, ErrUnknownPseudonym, err)
+}
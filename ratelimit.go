@@ -0,0 +1,180 @@
+package retraced
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a RateLimiter in RateLimitReject mode has no
+// capacity for a request.
+var ErrRateLimited = errors.New("retraced: client-side rate limit exceeded")
+
+// MetricStatusThrottled counts requests delayed or rejected by a RateLimiter.
+const MetricStatusThrottled = "throttled"
+
+// RateLimitMode selects what happens when a RateLimiter has no capacity.
+type RateLimitMode int
+
+const (
+	// RateLimitWait blocks until capacity is available or the context is done.
+	RateLimitWait RateLimitMode = iota
+	// RateLimitReject fails immediately with ErrRateLimited.
+	RateLimitReject
+)
+
+// RateLimit is a token bucket refilled at Rate tokens per second, holding up
+// to Burst tokens. The zero RateLimit is unlimited.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l *RateLimit) unlimited() bool {
+	return l == nil || l.Rate <= 0
+}
+
+// maxIdleBuckets bounds the number of per-group and per-action buckets kept
+// before full, idle buckets are discarded.
+const maxIdleBuckets = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits the rate of ReportEvent and GraphQL requests made by a
+// Client. Limit applies to all requests, and PerGroup and PerAction apply to
+// reported events of each Group.ID and Action. An event consumes a token from
+// every bucket it belongs to, or from none of them.
+// It is safe for concurrent access.
+type RateLimiter struct {
+	Limit     RateLimit
+	PerGroup  *RateLimit
+	PerAction *RateLimit
+	Mode      RateLimitMode
+
+	global  tokenBucket
+	groups  map[string]*tokenBucket
+	actions map[string]*tokenBucket
+	mtx     sync.Mutex
+}
+
+type bucketLimit struct {
+	bucket *tokenBucket
+	limit  *RateLimit
+}
+
+// wait takes a token for a request, and for the event if it is not nil. It
+// returns whether the request was throttled.
+func (l *RateLimiter) wait(ctx context.Context, event *Event) (bool, error) {
+	throttled := false
+	for {
+		delay := l.take(event, time.Now())
+		if delay == 0 {
+			return throttled, nil
+		}
+		throttled = true
+		if l.Mode == RateLimitReject {
+			return throttled, ErrRateLimited
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return throttled, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take consumes a token from every applicable bucket if all have one, and
+// otherwise returns how long until they will.
+func (l *RateLimiter) take(event *Event, now time.Time) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	buckets := make([]bucketLimit, 0, 3)
+	if !l.Limit.unlimited() {
+		buckets = append(buckets, bucketLimit{&l.global, &l.Limit})
+	}
+	if event != nil {
+		if !l.PerGroup.unlimited() && event.Group != nil {
+			l.groups = bucketFor(l.groups, event.Group.ID, l.PerGroup, now)
+			buckets = append(buckets, bucketLimit{l.groups[event.Group.ID], l.PerGroup})
+		}
+		if !l.PerAction.unlimited() {
+			l.actions = bucketFor(l.actions, event.Action, l.PerAction, now)
+			buckets = append(buckets, bucketLimit{l.actions[event.Action], l.PerAction})
+		}
+	}
+
+	var delay time.Duration
+	for _, b := range buckets {
+		refill(b.bucket, b.limit, now)
+		if b.bucket.tokens < 1 {
+			missing := time.Duration(math.Ceil((1 - b.bucket.tokens) / b.limit.Rate * float64(time.Second)))
+			if missing > delay {
+				delay = missing
+			}
+		}
+	}
+	if delay > 0 {
+		return delay
+	}
+	for _, b := range buckets {
+		b.bucket.tokens--
+	}
+	return 0
+}
+
+func burst(limit *RateLimit) float64 {
+	if limit.Burst < 1 {
+		return 1
+	}
+	return float64(limit.Burst)
+}
+
+func refill(b *tokenBucket, limit *RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = burst(limit)
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst(limit), b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+}
+
+// bucketFor makes sure buckets holds a bucket for key, discarding full
+// buckets when there are too many.
+func bucketFor(buckets map[string]*tokenBucket, key string, limit *RateLimit, now time.Time) map[string]*tokenBucket {
+	if buckets == nil {
+		buckets = make(map[string]*tokenBucket)
+	}
+	if _, ok := buckets[key]; ok {
+		return buckets
+	}
+	if len(buckets) >= maxIdleBuckets {
+		for k, b := range buckets {
+			refill(b, limit, now)
+			if b.tokens >= burst(limit) {
+				delete(buckets, k)
+			}
+		}
+	}
+	buckets[key] = &tokenBucket{}
+	return buckets
+}
+
+// rateLimit applies the client's RateLimiter, if any, to an operation.
+func (c *Client) rateLimit(ctx context.Context, operation string, event *Event) error {
+	if c.RateLimiter == nil {
+		return nil
+	}
+	throttled, err := c.RateLimiter.wait(ctx, event)
+	if throttled {
+		c.metrics().IncCounter(operation, MetricStatusThrottled)
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package retraced
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterBuckets(t *testing.T) {
+	l := &RateLimiter{
+		Limit:    RateLimit{Rate: 100, Burst: 3},
+		PerGroup: &RateLimit{Rate: 1, Burst: 1},
+	}
+	now := time.Now()
+	g1 := &Event{Action: "a", Group: &Group{ID: "g1"}}
+	g2 := &Event{Action: "a", Group: &Group{ID: "g2"}}
+
+	assert.Zero(t, l.take(g1, now))
+	assert.Equal(t, time.Second, l.take(g1, now), "group quota exhausted")
+	assert.Zero(t, l.take(g2, now))
+	assert.Zero(t, l.take(nil, now))
+	assert.Equal(t, 10*time.Millisecond, l.take(nil, now), "global limit exhausted")
+
+	// A rejected event must not consume global capacity.
+	assert.Zero(t, l.take(nil, now.Add(10*time.Millisecond)))
+	assert.Zero(t, l.take(g1, now.Add(time.Second)))
+}
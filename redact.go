@@ -0,0 +1,226 @@
+package retraced
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// RedactAction is what a Redactor does with sensitive data.
+type RedactAction int
+
+const (
+	// RedactMask replaces the sensitive data with the Redactor's Mask.
+	RedactMask RedactAction = iota
+	// RedactDrop removes the field, or empties the Description.
+	RedactDrop
+	// RedactHash replaces the sensitive data with a keyed HMAC-SHA256 digest,
+	// so equal values can still be correlated.
+	RedactHash
+)
+
+const defaultRedactionMask = "[REDACTED]"
+
+// RedactionRule redacts every field whose key matches Key.
+type RedactionRule struct {
+	// Key is an exact field key or a glob as understood by path.Match, e.g. "*password*"
+	Key string
+	// Action is applied to the whole field value
+	Action RedactAction
+}
+
+// Detector finds sensitive data inside free-form values.
+type Detector struct {
+	// Name describes what the detector finds
+	Name string
+	// Pattern matches candidate values
+	Pattern *regexp.Regexp
+	// Validate, when set, filters out false positives among Pattern matches
+	Validate func(match string) bool
+	// Action is applied to each match
+	Action RedactAction
+}
+
+// Redactor scrubs PII and secrets from events before they are reported. Rules
+// apply to Fields and Actor.Fields by key; Detectors apply to the Description
+// and to every value of Fields and Actor.Fields not already handled by a rule.
+type Redactor struct {
+	Rules     []RedactionRule
+	Detectors []Detector
+	// HMACKey is required by the RedactHash action
+	HMACKey []byte
+	// Mask replaces data for RedactMask, default is "[REDACTED]"
+	Mask string
+}
+
+var (
+	emailPattern        = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	cardNumberPattern   = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+	jwtPattern          = regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`)
+	bearerPattern       = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/\-]+=*`)
+	awsAccessKeyPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)
+	secretAssignPattern = regexp.MustCompile(`(?i)\b(?:password|passwd|secret|api[_\-]?key|token)\s*[:=]\s*\S+`)
+)
+
+// DefaultDetectors returns detectors for email addresses, payment card
+// numbers, JWTs, bearer tokens, AWS access keys and secret assignments such as
+// "password=hunter2". All of them mask their matches.
+func DefaultDetectors() []Detector {
+	return []Detector{
+		{Name: "email", Pattern: emailPattern},
+		{Name: "card_number", Pattern: cardNumberPattern, Validate: luhnValid},
+		{Name: "jwt", Pattern: jwtPattern},
+		{Name: "bearer_token", Pattern: bearerPattern},
+		{Name: "aws_access_key", Pattern: awsAccessKeyPattern},
+		{Name: "secret_assignment", Pattern: secretAssignPattern},
+	}
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// Redact scrubs the event in place. Fields maps and the Actor are replaced
+// rather than modified, so values shared with the caller are left untouched.
+func (r *Redactor) Redact(event *Event) error {
+	if event.Description != "" {
+		description, drop, err := r.scrub(event.Description)
+		if err != nil {
+			return err
+		}
+		if drop {
+			description = ""
+		}
+		event.Description = description
+	}
+
+	fields, err := r.redactFields(event.Fields)
+	if err != nil {
+		return err
+	}
+	event.Fields = fields
+
+	if event.Actor != nil && len(event.Actor.Fields) > 0 {
+		actorFields, err := r.redactFields(event.Actor.Fields)
+		if err != nil {
+			return err
+		}
+		actor := *event.Actor
+		actor.Fields = actorFields
+		event.Actor = &actor
+	}
+
+	return nil
+}
+
+func (r *Redactor) redactFields(fields Fields) (Fields, error) {
+	if len(fields) == 0 {
+		return fields, nil
+	}
+	redacted := make(Fields, len(fields))
+	for k, v := range fields {
+		rule, err := r.rule(k)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			if rule.Action == RedactDrop {
+				continue
+			}
+			if redacted[k], err = r.apply(rule.Action, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		value, drop, err := r.scrub(v)
+		if err != nil {
+			return nil, err
+		}
+		if !drop {
+			redacted[k] = value
+		}
+	}
+	return redacted, nil
+}
+
+// rule returns the first rule matching key, if any.
+func (r *Redactor) rule(key string) (*RedactionRule, error) {
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Key == key {
+			return rule, nil
+		}
+		matched, err := path.Match(rule.Key, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction rule %q: %v", rule.Key, err)
+		}
+		if matched {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// scrub runs the detectors over value. drop is true if a detector with the
+// RedactDrop action matched.
+func (r *Redactor) scrub(value string) (scrubbed string, drop bool, err error) {
+	for _, d := range r.Detectors {
+		value = d.Pattern.ReplaceAllStringFunc(value, func(match string) string {
+			if err != nil || (d.Validate != nil && !d.Validate(match)) {
+				return match
+			}
+			if d.Action == RedactDrop {
+				drop = true
+				return match
+			}
+			var replaced string
+			replaced, err = r.apply(d.Action, match)
+			return replaced
+		})
+		if err != nil || drop {
+			return "", drop, err
+		}
+	}
+	return value, false, nil
+}
+
+func (r *Redactor) apply(action RedactAction, value string) (string, error) {
+	switch action {
+	case RedactHash:
+		if len(r.HMACKey) == 0 {
+			return "", errors.New("redaction with RedactHash requires an HMACKey")
+		}
+		mac := hmac.New(sha256.New, r.HMACKey)
+		mac.Write([]byte(value))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+	case RedactDrop:
+		return "", nil
+	default:
+		if r.Mask == "" {
+			return defaultRedactionMask, nil
+		}
+		return r.Mask, nil
+	}
+}
@@ -0,0 +1,50 @@
+package retraced
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	r := &Redactor{
+		Rules: []RedactionRule{
+			{Key: "*password*", Action: RedactDrop},
+			{Key: "ssn", Action: RedactMask},
+			{Key: "user_*", Action: RedactHash},
+		},
+		Detectors: DefaultDetectors(),
+		HMACKey:   []byte("k"),
+	}
+	actorFields := Fields{"contact": "alice@example.com"}
+	event := &Event{
+		Action:      "card.add",
+		Description: "card 4111 1111 1111 1111 added by alice@example.com, ref 1234567890123",
+		Actor:       &Actor{ID: "a1", Fields: actorFields},
+		Fields: Fields{
+			"new_password": "hunter2",
+			"ssn":          "123-45-6789",
+			"user_id":      "42",
+			"auth":         "Bearer abc.def",
+			"plain":        "nothing to see",
+		},
+	}
+	require.NoError(t, r.Redact(event))
+
+	assert.Equal(t, "card [REDACTED] added by [REDACTED], ref 1234567890123", event.Description)
+	assert.Equal(t, Fields{
+		"ssn":     "[REDACTED]",
+		"user_id": event.Fields["user_id"],
+		"auth":    "[REDACTED]",
+		"plain":   "nothing to see",
+	}, event.Fields)
+	assert.Regexp(t, `^hmac:[0-9a-f]{32}$`, event.Fields["user_id"])
+	assert.Equal(t, "[REDACTED]", event.Actor.Fields["contact"])
+	assert.Equal(t, "alice@example.com", actorFields["contact"], "caller's map must not be modified")
+}
+
+func TestRedactHashRequiresKey(t *testing.T) {
+	r := &Redactor{Rules: []RedactionRule{{Key: "id", Action: RedactHash}}}
+	assert.Error(t, r.Redact(&Event{Action: "a", Fields: Fields{"id": "1"}}))
+}
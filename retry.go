@@ -0,0 +1,154 @@
+package retraced
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MetricAPIRequest counts retried API requests, with status MetricStatusRetry.
+const MetricAPIRequest = "api_request"
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultMaxRetryBackoff = 10 * time.Second
+)
+
+// retryable reports whether a request that ended with resp and err may be
+// sent again: transport errors, 429 and temporary 5xx responses.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil || err == ErrCircuitOpen {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retry attempt, which starts at
+// 0. A Retry-After header in seconds takes precedence over the exponential
+// backoff with jitter. Both are capped by MaxRetryBackoff.
+func (c *Client) retryDelay(attempt int, resp *http.Response) time.Duration {
+	max := c.MaxRetryBackoff
+	if max <= 0 {
+		max = defaultMaxRetryBackoff
+	}
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if delay := time.Duration(seconds) * time.Second; delay < max {
+				return delay
+			}
+			return max
+		}
+	}
+
+	delay := c.RetryBackoff
+	if delay <= 0 {
+		delay = defaultRetryBackoff
+	}
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do sends an API request with doOnce, authorized with the token of the
+// client's TokenProvider. After a 401 response, the token is refreshed and the
+// request sent once more. Failed requests are retried up to MaxRetries times.
+// The request body is replayed with GetBody, so requests without it are sent
+// only once.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	provider := c.tokenProvider()
+	token, err := provider.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	authorize := c.authorization
+	if authorize == nil {
+		authorize = tokenAuthorization
+	}
+	if token != "" {
+		req.Header.Set("Authorization", authorize(token))
+	}
+	replayable := req.Body == nil || req.GetBody != nil
+
+	refreshed := false
+	for attempt := 0; ; {
+		resp, err := c.doOnce(req)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && !refreshed && replayable {
+			refreshed = true
+			fresh, rerr := provider.Refresh(ctx, token)
+			if rerr != nil {
+				c.logger().WarnContext(ctx, "retraced: failed to refresh api token", "error", rerr)
+				return resp, nil
+			}
+			if fresh == token {
+				return resp, nil
+			}
+			drain(resp)
+			c.metrics().IncCounter(MetricAPIRequest, MetricStatusTokenRefresh)
+			token = fresh
+			req.Header.Set("Authorization", authorize(token))
+			if err := rewind(req); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if attempt >= c.MaxRetries || !retryable(ctx, resp, err) || !replayable {
+			return resp, err
+		}
+
+		delay := c.retryDelay(attempt, resp)
+		attempt++
+		drain(resp)
+		c.metrics().IncCounter(MetricAPIRequest, MetricStatusRetry)
+		c.logger().DebugContext(ctx, "retraced: retrying api request", "method", req.Method, "url", req.URL.String(), "attempt", attempt, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if err := rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// drain discards the rest of resp's body so the connection can be reused.
+func drain(resp *http.Response) {
+	if resp != nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// rewind resets the body of req to be sent again.
+func rewind(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
@@ -0,0 +1,187 @@
+package retraced
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrNoRoute is returned by Router when no Client is registered for an event
+// or project.
+var ErrNoRoute = errors.New("retraced: no client for route")
+
+// Router holds one Client per project and reports each event through the
+// Client selected by Route. It is safe for concurrent access.
+type Router struct {
+	// Route returns the key of the Client that reports event
+	Route func(event *Event) string
+	// Default is the key used when Route is nil or returns a key with no Client
+	Default string
+
+	clients map[string]*Client
+	mtx     sync.RWMutex
+}
+
+// NewRouter creates a Router that selects Clients with route.
+func NewRouter(route func(event *Event) string) *Router {
+	return &Router{
+		Route:   route,
+		clients: map[string]*Client{},
+	}
+}
+
+// RouteByComponent routes events by their Component.
+func RouteByComponent(event *Event) string {
+	return event.Component
+}
+
+// RouteByGroup routes events by their Group ID.
+func RouteByGroup(event *Event) string {
+	if event.Group == nil {
+		return ""
+	}
+	return event.Group.ID
+}
+
+// Register adds or replaces the Client for key.
+func (r *Router) Register(key string, client *Client) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.clients == nil {
+		r.clients = map[string]*Client{}
+	}
+	r.clients[key] = client
+}
+
+// Client returns the Client registered for key.
+func (r *Router) Client(key string) (*Client, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	client, ok := r.clients[key]
+	return client, ok
+}
+
+// Keys returns the keys of all registered Clients in sorted order.
+func (r *Router) Keys() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	keys := make([]string, 0, len(r.clients))
+	for key := range r.clients {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *Router) client(key string) (*Client, error) {
+	if client, ok := r.Client(key); ok {
+		return client, nil
+	}
+	if client, ok := r.Client(r.Default); ok {
+		return client, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrNoRoute, key)
+}
+
+func (r *Router) route(event *Event) (*Client, error) {
+	key := ""
+	if r.Route != nil {
+		key = r.Route(event)
+	}
+	return r.client(key)
+}
+
+// ReportEvent reports event through the Client selected by Route.
+func (r *Router) ReportEvent(event *Event) (*NewEventRecord, error) {
+	client, err := r.route(event)
+	if err != nil {
+		return nil, err
+	}
+	return client.ReportEvent(event)
+}
+
+// ReportEventContext is like ReportEvent, but fills the event's missing values
+// from the audit scope carried by ctx before it is routed, see
+// Client.ReportEventContext.
+func (r *Router) ReportEventContext(ctx context.Context, event *Event) (*NewEventRecord, error) {
+	applyScope(ctx, event)
+	client, err := r.route(event)
+	if err != nil {
+		return nil, err
+	}
+	return client.ReportEventContext(ctx, event)
+}
+
+// Query searches the events of the project registered for key.
+func (r *Router) Query(key string, sq *StructuredQuery, mask *EventNodeMask, pageSize int) (EventsPager, error) {
+	return r.QueryContext(context.Background(), key, sq, mask, pageSize)
+}
+
+// QueryContext is like Query, but every page request is made with ctx.
+func (r *Router) QueryContext(ctx context.Context, key string, sq *StructuredQuery, mask *EventNodeMask, pageSize int) (EventsPager, error) {
+	client, err := r.client(key)
+	if err != nil {
+		return nil, err
+	}
+	return client.QueryContext(ctx, sq, mask, pageSize)
+}
+
+// NewStream streams the events of the project registered for key.
+func (r *Router) NewStream(key string, sq *StructuredQuery, mask *EventNodeMask) (*Stream, error) {
+	client, err := r.client(key)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewStream(sq, mask)
+}
+
+// StreamAll streams the query's events from every registered project as a
+// single feed, see MergeStreams. The first page of every project is fetched
+// concurrently. The mask should include CanonicalTime and ID.
+func (r *Router) StreamAll(ctx context.Context, sq *StructuredQuery, mask *EventNodeMask) (*MergedStream, error) {
+	keys := r.Keys()
+	streams := make([]EventReader, len(keys))
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		client, _ := r.Client(key)
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			streams[i], errs[i] = client.NewStreamContext(ctx, sq, mask)
+		}(i, client)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("querying %q: %w", key, errs[i])
+		}
+	}
+	return MergeStreams(ctx, streams...), nil
+}
+
+// QueryAll returns at most limit events of StreamAll, or all events if limit
+// is 0.
+func (r *Router) QueryAll(ctx context.Context, sq *StructuredQuery, mask *EventNodeMask, limit int) ([]*EventNode, error) {
+	stream, err := r.StreamAll(ctx, sq, mask)
+	if err != nil {
+		return nil, err
+	}
+	var events []*EventNode
+	for limit == 0 || len(events) < limit {
+		event, err := stream.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,76 @@
+package retraced
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// searchServer answers GraphQL searches with a single page of events.
+func searchServer(events ...*EventNode) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		root := &graphQLSearchRoot{}
+		root.Data.Search.TotalCount = len(events)
+		for _, event := range events {
+			root.Data.Search.Edges = append(root.Data.Search.Edges, &EventEdge{Cursor: event.ID, Node: event})
+		}
+		json.NewEncoder(w).Encode(root)
+	}))
+}
+
+func TestRouterReportEvent(t *testing.T) {
+	router := NewRouter(RouteByComponent)
+	router.Register("billing", &Client{})
+	_, err := router.ReportEvent(&Event{Action: "a", Component: "unknown"})
+	assert.ErrorIs(t, err, ErrNoRoute)
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/publisher/v1/project/p2/event", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"abc"}`))
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, "p2", "token")
+	require.NoError(t, err)
+	client.HashVerification = HashVerificationOff
+	router.Register("search", client)
+
+	record, err := router.ReportEvent(&Event{Action: "a", Component: "search"})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", record.ID)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRouterQueryAll(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	at := func(id string, ago int) *EventNode {
+		return &EventNode{ID: id, CanonicalTime: now.Add(-time.Duration(ago) * time.Minute)}
+	}
+	one := searchServer(at("a", 1), at("c", 3), at("e", 5))
+	defer one.Close()
+	two := searchServer(at("b", 2), at("d", 3))
+	defer two.Close()
+
+	router := NewRouter(nil)
+	for key, server := range map[string]*httptest.Server{"one": one, "two": two} {
+		client, err := NewClient(server.URL, key, "token")
+		require.NoError(t, err)
+		router.Register(key, client)
+	}
+
+	events, err := router.QueryAll(context.Background(), &StructuredQuery{}, &EventNodeMask{ID: true, CanonicalTime: true}, 4)
+	require.NoError(t, err)
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, ids)
+}
@@ -0,0 +1,74 @@
+package retraced
+
+import "context"
+
+type scopeContextKey int
+
+const (
+	actorContextKey scopeContextKey = iota
+	groupContextKey
+	sourceIPContextKey
+	metadataContextKey
+)
+
+// WithActor returns a context carrying the Actor for events reported with it.
+func WithActor(ctx context.Context, actor *Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// WithGroup returns a context carrying the Group for events reported with it.
+func WithGroup(ctx context.Context, group *Group) context.Context {
+	return context.WithValue(ctx, groupContextKey, group)
+}
+
+// WithSourceIP returns a context carrying the SourceIP for events reported with it.
+func WithSourceIP(ctx context.Context, sourceIP string) context.Context {
+	return context.WithValue(ctx, sourceIPContextKey, sourceIP)
+}
+
+// WithMetadata returns a context carrying Metadata for events reported with it.
+// The values are merged with Metadata already in ctx, replacing equal keys.
+func WithMetadata(ctx context.Context, metadata Fields) context.Context {
+	return context.WithValue(ctx, metadataContextKey, withDefaults(metadata, MetadataFromContext(ctx)))
+}
+
+// ActorFromContext returns the Actor set with WithActor, or nil.
+func ActorFromContext(ctx context.Context) *Actor {
+	actor, _ := ctx.Value(actorContextKey).(*Actor)
+	return actor
+}
+
+// GroupFromContext returns the Group set with WithGroup, or nil.
+func GroupFromContext(ctx context.Context) *Group {
+	group, _ := ctx.Value(groupContextKey).(*Group)
+	return group
+}
+
+// SourceIPFromContext returns the source ip set with WithSourceIP, or "".
+func SourceIPFromContext(ctx context.Context) string {
+	sourceIP, _ := ctx.Value(sourceIPContextKey).(string)
+	return sourceIP
+}
+
+// MetadataFromContext returns the Metadata set with WithMetadata, or nil.
+func MetadataFromContext(ctx context.Context) Fields {
+	metadata, _ := ctx.Value(metadataContextKey).(Fields)
+	return metadata
+}
+
+// applyScope fills the event's missing Actor, Group and SourceIP from ctx and
+// adds Metadata from ctx for keys the event does not set.
+func applyScope(ctx context.Context, event *Event) {
+	if event.Actor == nil {
+		event.Actor = ActorFromContext(ctx)
+	}
+	if event.Group == nil {
+		event.Group = GroupFromContext(ctx)
+	}
+	if event.SourceIP == "" {
+		event.SourceIP = SourceIPFromContext(ctx)
+	}
+	if metadata := MetadataFromContext(ctx); len(metadata) > 0 {
+		event.Metadata = withDefaults(event.Metadata, metadata)
+	}
+}
@@ -0,0 +1,24 @@
+package retraced
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyScope(t *testing.T) {
+	ctx := WithActor(context.Background(), &Actor{ID: "u1"})
+	ctx = WithGroup(ctx, &Group{ID: "g1"})
+	ctx = WithSourceIP(ctx, "192.0.2.1")
+	ctx = WithMetadata(ctx, Fields{"request_id": "r1", "env": "prod"})
+	ctx = WithMetadata(ctx, Fields{"env": "staging"})
+
+	event := &Event{Action: "a", Group: &Group{ID: "g2"}, Metadata: Fields{"request_id": "r2"}}
+	applyScope(ctx, event)
+
+	assert.Equal(t, "u1", event.Actor.ID)
+	assert.Equal(t, "g2", event.Group.ID)
+	assert.Equal(t, "192.0.2.1", event.SourceIP)
+	assert.Equal(t, Fields{"request_id": "r2", "env": "staging"}, event.Metadata)
+}
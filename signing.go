@@ -0,0 +1,235 @@
+package retraced
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// SignatureMetadataKey is the Metadata key holding an event's base64 signature
+	SignatureMetadataKey = "retraced.signature"
+	// SignatureKeyIDMetadataKey is the Metadata key holding the id of the signing key
+	SignatureKeyIDMetadataKey = "retraced.signature.key_id"
+	// SignatureFieldsMetadataKey is the Metadata key listing, as a JSON array,
+	// the Component, Version, Group, Actor and Target details that are signed
+	SignatureFieldsMetadataKey = "retraced.signature.fields"
+)
+
+var (
+	// ErrMissingSignature is returned when an event carries no signature.
+	ErrMissingSignature = errors.New("event is not signed")
+	// ErrUnknownSigningKey is returned when an event was signed by a key not in the KeySet.
+	ErrUnknownSigningKey = errors.New("event signed with unknown key")
+	// ErrInvalidSignature is returned when an event signature does not verify.
+	ErrInvalidSignature = errors.New("event signature is invalid")
+)
+
+// EventSigner signs events with a service's ed25519 key before they are reported.
+type EventSigner struct {
+	// KeyID identifies Key in a KeySet
+	KeyID string
+	// Key is the private signing key
+	Key ed25519.PrivateKey
+}
+
+// KeySet maps key ids to the public keys trusted to sign events.
+type KeySet map[string]ed25519.PublicKey
+
+// Sign computes the event signature and stores it, the key id and the list of
+// signed details in Metadata.
+func (s *EventSigner) Sign(event *Event) {
+	details, _ := json.Marshal(event.presentDetails())
+
+	metadata := make(Fields, len(event.Metadata)+3)
+	for k, v := range event.Metadata {
+		metadata[k] = v
+	}
+	metadata[SignatureFieldsMetadataKey] = string(details)
+	event.Metadata = metadata
+
+	signature := ed25519.Sign(s.Key, event.BuildSignatureTarget())
+	metadata[SignatureMetadataKey] = base64.StdEncoding.EncodeToString(signature)
+	metadata[SignatureKeyIDMetadataKey] = s.KeyID
+}
+
+// BuildSignatureTarget builds the canonical serialization of the event that is
+// signed by an EventSigner. It starts from the hash target, without the
+// server-assigned id, and adds the CRUD, Created and Description values and
+// the Component, Version, Group, Actor and Target details the hash does not
+// cover. The Retraced API fills in details of known entities that an event
+// leaves empty, so only the details the signed event set are covered: they
+// are listed under SignatureFieldsMetadataKey, or taken from the event if it
+// is not signed yet. The signature and key id are excluded.
+func (event *Event) BuildSignatureTarget() []byte {
+	unsigned := *event
+	if len(event.Metadata) > 0 {
+		unsigned.Metadata = make(Fields, len(event.Metadata))
+		for k, v := range event.Metadata {
+			if k == SignatureMetadataKey || k == SignatureKeyIDMetadataKey {
+				continue
+			}
+			unsigned.Metadata[k] = v
+		}
+	}
+
+	concat := &bytes.Buffer{}
+	concat.WriteString("retraced-signature:v1\n")
+	concat.Write(unsigned.BuildHashTarget(&NewEventRecord{}))
+	concat.WriteString("\n")
+
+	fmt.Fprintf(concat, "%s:", encodePassOne(event.CRUD))
+	if !event.Created.IsZero() {
+		fmt.Fprintf(concat, "%d", event.Created.UnixMilli())
+	}
+	fmt.Fprintf(concat, ":%s\n", encodePassOne(event.Description))
+
+	for _, name := range event.signedDetails() {
+		fmt.Fprintf(concat, "%s=%s;", encodePassTwo(encodePassOne(name)), encodePassTwo(encodePassOne(event.signatureDetail(name))))
+	}
+
+	return concat.Bytes()
+}
+
+// signedDetails returns the names of the details covered by the signature.
+func (event *Event) signedDetails() []string {
+	listed, ok := event.Metadata[SignatureFieldsMetadataKey]
+	if !ok {
+		return event.presentDetails()
+	}
+	var details []string
+	if err := json.Unmarshal([]byte(listed), &details); err != nil {
+		return nil
+	}
+	return details
+}
+
+// presentDetails returns the names of the details the event sets, such as
+// "actor.name" or "target.fields.role", in canonical order.
+func (event *Event) presentDetails() []string {
+	var details []string
+	add := func(name, value string) {
+		if value != "" {
+			details = append(details, name)
+		}
+	}
+	addFields := func(prefix string, fields Fields) {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			details = append(details, prefix+k)
+		}
+	}
+
+	add("component", event.Component)
+	add("version", event.Version)
+	if event.Group != nil {
+		add("group.name", event.Group.Name)
+	}
+	if event.Actor != nil {
+		add("actor.name", event.Actor.Name)
+		add("actor.href", event.Actor.Href)
+		addFields("actor.fields.", event.Actor.Fields)
+	}
+	if event.Target != nil {
+		add("target.name", event.Target.Name)
+		add("target.type", event.Target.Type)
+		add("target.href", event.Target.Href)
+		addFields("target.fields.", event.Target.Fields)
+	}
+	return details
+}
+
+// signatureDetail returns the value of a detail named by presentDetails.
+func (event *Event) signatureDetail(name string) string {
+	actor, group, target := &Actor{}, &Group{}, &Target{}
+	if event.Actor != nil {
+		actor = event.Actor
+	}
+	if event.Group != nil {
+		group = event.Group
+	}
+	if event.Target != nil {
+		target = event.Target
+	}
+
+	switch name {
+	case "component":
+		return event.Component
+	case "version":
+		return event.Version
+	case "group.name":
+		return group.Name
+	case "actor.name":
+		return actor.Name
+	case "actor.href":
+		return actor.Href
+	case "target.name":
+		return target.Name
+	case "target.type":
+		return target.Type
+	case "target.href":
+		return target.Href
+	}
+	if k, ok := strings.CutPrefix(name, "actor.fields."); ok {
+		return actor.Fields[k]
+	}
+	if k, ok := strings.CutPrefix(name, "target.fields."); ok {
+		return target.Fields[k]
+	}
+	return ""
+}
+
+// VerifySignature checks the event signature stored in Metadata against keys.
+// The query mask must include every field covered by the signature: Action,
+// CRUD, Created, Description, SourceIP, IsFailure, IsAnonymous, Component,
+// Version, Fields, ExternalID, Metadata and all Group, Actor and Target fields.
+func (e *EventNode) VerifySignature(keys KeySet) error {
+	encoded := e.Metadata[SignatureMetadataKey]
+	if encoded == "" {
+		return ErrMissingSignature
+	}
+	key, ok := keys[e.Metadata[SignatureKeyIDMetadataKey]]
+	if !ok {
+		return ErrUnknownSigningKey
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	if !ed25519.Verify(key, e.reportedEvent().BuildSignatureTarget(), signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// reportedEvent reconstructs the reported Event from an EventNode.
+func (e *EventNode) reportedEvent() *Event {
+	return &Event{
+		Action:      e.Action,
+		Group:       e.Group,
+		Created:     e.Created,
+		CRUD:        e.CRUD,
+		Target:      e.Target,
+		Description: e.Description,
+		SourceIP:    e.SourceIP,
+		Actor:       e.Actor,
+		Fields:      e.Fields,
+		IsFailure:   e.IsFailure,
+		IsAnonymous: e.IsAnonymous,
+		Component:   e.Component,
+		Version:     e.Version,
+		ExternalID:  e.ExternalID,
+		Metadata:    e.Metadata,
+	}
+}
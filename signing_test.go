@@ -0,0 +1,68 @@
+package retraced
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer := &EventSigner{KeyID: "svc-1", Key: private}
+
+	event := &Event{
+		Action:      "user.update",
+		Group:       &Group{ID: "g1"},
+		Created:     time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC),
+		CRUD:        "u",
+		Actor:       &Actor{ID: "a1", Name: "alice@example.com", Fields: Fields{"role": "admin"}},
+		Target:      &Target{ID: "t1", Name: "bob@example.com"},
+		Description: "changed email",
+		Component:   "accounts",
+		Fields:      Fields{"old": "a", "new": "b"},
+		Metadata:    Fields{"region": "eu"},
+	}
+	signer.Sign(event)
+	assert.Equal(t, `["component","actor.name","actor.fields.role","target.name"]`, event.Metadata[SignatureFieldsMetadataKey])
+
+	// The API fills in details of known entities that the event left empty.
+	node := &EventNode{
+		Action:      event.Action,
+		Group:       &Group{ID: "g1", Name: "Group One"},
+		Created:     event.Created,
+		CRUD:        event.CRUD,
+		Actor:       &Actor{ID: "a1", Name: "alice@example.com", Href: "https://example.com/users/a1", Fields: Fields{"role": "admin"}},
+		Target:      &Target{ID: "t1", Name: "bob@example.com", Type: "user"},
+		Description: event.Description,
+		Component:   event.Component,
+		Fields:      event.Fields,
+		Metadata:    event.Metadata,
+	}
+	keys := KeySet{"svc-1": public}
+	assert.NoError(t, node.VerifySignature(keys))
+
+	// Signed details can't be changed.
+	node.Actor.Name = "mallory@example.com"
+	assert.Equal(t, ErrInvalidSignature, node.VerifySignature(keys))
+	node.Actor.Name = "alice@example.com"
+	node.Actor.Fields = Fields{"role": "owner"}
+	assert.Equal(t, ErrInvalidSignature, node.VerifySignature(keys))
+	node.Actor.Fields = Fields{"role": "admin"}
+	node.Target.Name = "carol@example.com"
+	assert.Equal(t, ErrInvalidSignature, node.VerifySignature(keys))
+	node.Target.Name = "bob@example.com"
+	node.Component = "billing"
+	assert.Equal(t, ErrInvalidSignature, node.VerifySignature(keys))
+	node.Component = "accounts"
+	node.Description = "changed nothing"
+	assert.Equal(t, ErrInvalidSignature, node.VerifySignature(keys))
+	node.Description = event.Description
+	assert.NoError(t, node.VerifySignature(keys))
+
+	assert.Equal(t, ErrUnknownSigningKey, node.VerifySignature(KeySet{}))
+	assert.Equal(t, ErrMissingSignature, (&EventNode{}).VerifySignature(keys))
+}
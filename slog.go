@@ -0,0 +1,282 @@
+package retraced
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// SlogKeys names the slog attributes that SlogHandler maps onto an Event.
+// Attributes in groups are matched by their dotted path, e.g. an "id"
+// attribute in an "actor" group matches "actor.id". Groups opened with
+// Logger.WithGroup are not part of the matched path, so a logger from
+// WithGroup("http") still reports "audit" records, but they are kept in the
+// keys of Fields, e.g. "http.path".
+type SlogKeys struct {
+	// Marker marks a record as an audit event when its value is true
+	Marker     string
+	Action     string
+	CRUD       string
+	SourceIP   string
+	IsFailure  string
+	ActorID    string
+	ActorName  string
+	GroupID    string
+	GroupName  string
+	TargetID   string
+	TargetName string
+	TargetType string
+}
+
+// DefaultSlogKeys returns the default attribute names: "audit", "action",
+// "crud", "source_ip", "failure", "actor.id", "actor.name", "group.id",
+// "group.name", "target.id", "target.name" and "target.type".
+func DefaultSlogKeys() SlogKeys {
+	return SlogKeys{
+		Marker:     "audit",
+		Action:     "action",
+		CRUD:       "crud",
+		SourceIP:   "source_ip",
+		IsFailure:  "failure",
+		ActorID:    "actor.id",
+		ActorName:  "actor.name",
+		GroupID:    "group.id",
+		GroupName:  "group.name",
+		TargetID:   "target.id",
+		TargetName: "target.name",
+		TargetType: "target.type",
+	}
+}
+
+// SlogHandlerOptions configures a SlogHandler.
+type SlogHandlerOptions struct {
+	// Keys maps attributes onto events, default is DefaultSlogKeys
+	Keys *SlogKeys
+	// Level is the minimum level of audit records, default is slog.LevelInfo
+	Level slog.Leveler
+	// ForwardAudit also passes audit records to the wrapped handler
+	ForwardAudit bool
+	// OnError is called when an audit record fails to report, because slog
+	// ignores the errors of handlers. The default logs the error.
+	OnError func(event *Event, err error)
+	// Logger receives errors when OnError is not set, default is the Logger of
+	// the reporter if it is a Client, else slog.Default()
+	Logger Logger
+}
+
+// SlogHandler is an slog.Handler that reports records carrying the marker
+// attribute as Events and passes all other records to a wrapped handler.
+// The record message becomes the Description, and its time the Created
+// timestamp. If no action attribute is present, the message is used as the
+// Action. Attributes not mapped by the keys are flattened into Fields.
+type SlogHandler struct {
+	reporter EventReporter
+	next     slog.Handler
+	keys     SlogKeys
+	level    slog.Leveler
+	forward  bool
+	onError  func(event *Event, err error)
+	logger   Logger
+	attrs    []flatAttr
+	prefix   string
+}
+
+// flatAttr is an attribute with a dotted key. group holds the groups opened
+// with WithGroup, which are not matched against SlogKeys.
+type flatAttr struct {
+	group string
+	key   string
+	value slog.Value
+}
+
+// NewSlogHandler creates a handler that reports audit records through
+// reporter and passes other records to next, which may be nil.
+func NewSlogHandler(reporter EventReporter, next slog.Handler, opts *SlogHandlerOptions) *SlogHandler {
+	if opts == nil {
+		opts = &SlogHandlerOptions{}
+	}
+	h := &SlogHandler{
+		reporter: reporter,
+		next:     next,
+		keys:     DefaultSlogKeys(),
+		level:    opts.Level,
+		forward:  opts.ForwardAudit,
+		onError:  opts.OnError,
+		logger:   opts.Logger,
+	}
+	if opts.Keys != nil {
+		h.keys = *opts.Keys
+	}
+	if h.level == nil {
+		h.level = slog.LevelInfo
+	}
+	return h
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() || (h.next != nil && h.next.Enabled(ctx, level))
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := append([]flatAttr(nil), h.attrs...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = flattenAttr(attrs, h.prefix, "", a)
+		return true
+	})
+
+	if !h.isAudit(attrs) {
+		return h.forwardRecord(ctx, record)
+	}
+
+	if record.Level >= h.level.Level() {
+		event := h.event(record, attrs)
+		if err := h.report(ctx, event); err != nil {
+			h.reportError(ctx, event, err)
+		}
+	}
+	if h.forward {
+		return h.forwardRecord(ctx, record)
+	}
+	return nil
+}
+
+func (h *SlogHandler) forwardRecord(ctx context.Context, record slog.Record) error {
+	if h.next == nil || !h.next.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *SlogHandler) report(ctx context.Context, event *Event) error {
+	_, err := reportEventContext(ctx, h.reporter, event)
+	return err
+}
+
+func (h *SlogHandler) reportError(ctx context.Context, event *Event, err error) {
+	if h.onError != nil {
+		h.onError(event, err)
+		return
+	}
+	logger := h.logger
+	if logger == nil {
+		logger = reporterLogger(h.reporter)
+	}
+	logger.ErrorContext(ctx, "retraced: failed to report event", "action", event.Action, "error", err)
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]flatAttr(nil), h.attrs...)
+	for _, a := range attrs {
+		clone.attrs = flattenAttr(clone.attrs, h.prefix, "", a)
+	}
+	if h.next != nil {
+		clone.next = h.next.WithAttrs(attrs)
+	}
+	return &clone
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	if h.next != nil {
+		clone.next = h.next.WithGroup(name)
+	}
+	return &clone
+}
+
+func (h *SlogHandler) isAudit(attrs []flatAttr) bool {
+	for _, a := range attrs {
+		if a.key == h.keys.Marker && slogBool(a.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *SlogHandler) event(record slog.Record, attrs []flatAttr) *Event {
+	event := &Event{
+		Action:      record.Message,
+		Created:     record.Time,
+		Description: record.Message,
+	}
+	actor, group, target := &Actor{}, &Group{}, &Target{}
+
+	for _, a := range attrs {
+		value := a.value.String()
+		switch a.key {
+		case h.keys.Marker:
+		case h.keys.Action:
+			event.Action = value
+		case h.keys.CRUD:
+			event.CRUD = value
+		case h.keys.SourceIP:
+			event.SourceIP = value
+		case h.keys.IsFailure:
+			event.IsFailure = slogBool(a.value)
+		case h.keys.ActorID:
+			actor.ID = value
+		case h.keys.ActorName:
+			actor.Name = value
+		case h.keys.GroupID:
+			group.ID = value
+		case h.keys.GroupName:
+			group.Name = value
+		case h.keys.TargetID:
+			target.ID = value
+		case h.keys.TargetName:
+			target.Name = value
+		case h.keys.TargetType:
+			target.Type = value
+		default:
+			if event.Fields == nil {
+				event.Fields = Fields{}
+			}
+			event.Fields[a.group+a.key] = value
+		}
+	}
+
+	if actor.ID != "" || actor.Name != "" {
+		event.Actor = actor
+	}
+	if *group != (Group{}) {
+		event.Group = group
+	}
+	if target.ID != "" || target.Name != "" || target.Type != "" {
+		event.Target = target
+	}
+	return event
+}
+
+// flattenAttr appends a, with nested groups flattened into dotted keys.
+func flattenAttr(attrs []flatAttr, group, prefix string, a slog.Attr) []flatAttr {
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix = prefix + a.Key + "."
+		}
+		for _, member := range value.Group() {
+			attrs = flattenAttr(attrs, group, prefix, member)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	return append(attrs, flatAttr{group: group, key: prefix + a.Key, value: value})
+}
+
+func slogBool(v slog.Value) bool {
+	switch v.Kind() {
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindString:
+		b, _ := strconv.ParseBool(strings.TrimSpace(v.String()))
+		return b
+	}
+	return false
+}
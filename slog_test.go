@@ -0,0 +1,78 @@
+package retraced
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogHandler(t *testing.T) {
+	reporter := &recordingReporter{}
+	var out bytes.Buffer
+	logger := slog.New(NewSlogHandler(reporter, slog.NewTextHandler(&out, nil), nil))
+
+	logger = logger.With(slog.Group("group", slog.String("id", "g1")))
+	logger.Info("config changed",
+		"audit", true,
+		"action", "config.update",
+		slog.Group("actor", slog.String("id", "u1"), slog.String("name", "alice")),
+		slog.Group("change", slog.String("key", "timeout"), slog.Group("values", slog.Int("old", 5), slog.Int("new", 10))),
+	)
+	logger.Info("just a log line", "k", "v")
+
+	require.Len(t, reporter.events, 1)
+	event := reporter.events[0]
+	assert.Equal(t, "config.update", event.Action)
+	assert.Equal(t, "config changed", event.Description)
+	assert.Equal(t, &Actor{ID: "u1", Name: "alice"}, event.Actor)
+	assert.Equal(t, &Group{ID: "g1"}, event.Group)
+	assert.Nil(t, event.Target)
+	assert.Equal(t, Fields{"change.key": "timeout", "change.values.old": "5", "change.values.new": "10"}, event.Fields)
+
+	assert.Contains(t, out.String(), "just a log line")
+	assert.NotContains(t, out.String(), "config changed")
+}
+
+func TestSlogHandlerWithGroup(t *testing.T) {
+	reporter := &recordingReporter{}
+	logger := slog.New(NewSlogHandler(reporter, nil, nil)).WithGroup("http")
+	logger.Info("user deleted", "audit", true, "action", "user.delete", "path", "/users/1",
+		slog.Group("actor", slog.String("id", "u1")))
+
+	require.Len(t, reporter.events, 1)
+	event := reporter.events[0]
+	assert.Equal(t, "user.delete", event.Action)
+	assert.Equal(t, &Actor{ID: "u1"}, event.Actor)
+	assert.Equal(t, Fields{"http.path": "/users/1"}, event.Fields)
+}
+
+type failingReporter struct{}
+
+func (failingReporter) ReportEvent(event *Event) (*NewEventRecord, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestSlogHandlerOnError(t *testing.T) {
+	var failed []*Event
+	logger := slog.New(NewSlogHandler(failingReporter{}, nil, &SlogHandlerOptions{
+		OnError: func(event *Event, err error) {
+			assert.EqualError(t, err, "unavailable")
+			failed = append(failed, event)
+		},
+	}))
+	logger.Info("user deleted", "audit", true)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "user deleted", failed[0].Action)
+
+	var out bytes.Buffer
+	logger = slog.New(NewSlogHandler(failingReporter{}, nil, &SlogHandlerOptions{
+		Logger: slog.New(slog.NewTextHandler(&out, nil)),
+	}))
+	logger.Info("user deleted", "audit", true)
+	assert.Contains(t, out.String(), "failed to report event")
+	assert.Contains(t, out.String(), "unavailable")
+}
@@ -0,0 +1,183 @@
+package retraced
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ForwardingHeader is a header that proxies use to forward the client address.
+type ForwardingHeader string
+
+const (
+	// HeaderXForwardedFor is the X-Forwarded-For header, the default
+	HeaderXForwardedFor ForwardingHeader = "X-Forwarded-For"
+	// HeaderForwarded is the RFC 7239 Forwarded header
+	HeaderForwarded ForwardingHeader = "Forwarded"
+	// HeaderXRealIP is the X-Real-IP header
+	HeaderXRealIP ForwardingHeader = "X-Real-IP"
+)
+
+// TrustedProxies lists the proxies whose forwarding header is trusted.
+type TrustedProxies struct {
+	// Networks lists the networks of the trusted proxies
+	Networks []netip.Prefix
+	// Header is the forwarding header the trusted proxies set, default is
+	// X-Forwarded-For. Other forwarding headers may be sent by the client,
+	// so they are ignored.
+	Header ForwardingHeader
+}
+
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8" or single addresses
+// such as "192.0.2.1" into TrustedProxies that set X-Forwarded-For.
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	networks := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return TrustedProxies{}, err
+			}
+			addr = normalizeAddr(addr)
+			networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return TrustedProxies{}, err
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return TrustedProxies{Networks: networks}, nil
+}
+
+// Contains reports whether addr belongs to a trusted network.
+func (t TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = normalizeAddr(addr)
+	for _, prefix := range t.Networks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceIPFromRequest returns the address of the client that made the request.
+// The forwarding header of trusted is only honored when the peer is a trusted
+// proxy, and other forwarding headers are ignored. The forwarding chain is
+// walked from the right, and the first address that is not a trusted proxy is
+// returned. If a hop is obfuscated or malformed, the nearest trusted proxy is
+// returned instead, because nothing it reports about earlier hops can be
+// trusted.
+// IPv4-mapped IPv6 addresses are returned as IPv4, and IPv6 addresses in
+// their canonical form. It returns "" if RemoteAddr is not an address.
+func SourceIPFromRequest(r *http.Request, trusted TrustedProxies) string {
+	addr, ok := parseNode(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !trusted.Contains(addr) {
+		return addr.String()
+	}
+
+	hops := forwardedHops(r.Header, trusted.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseNode(hops[i])
+		if !ok {
+			break
+		}
+		addr = hop
+		if !trusted.Contains(hop) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// forwardedHops returns the client addresses of the forwarding chain in the
+// given header, from the original client to the nearest proxy.
+func forwardedHops(header http.Header, name ForwardingHeader) []string {
+	switch name {
+	case HeaderForwarded:
+		var hops []string
+		for _, element := range splitQuoted(strings.Join(header.Values("Forwarded"), ","), ',') {
+			hop := ""
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, _ := strings.Cut(pair, "=")
+				if strings.EqualFold(strings.TrimSpace(key), "for") {
+					hop = unquote(strings.TrimSpace(value))
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	case HeaderXRealIP:
+		if value := header.Get("X-Real-IP"); value != "" {
+			return []string{strings.TrimSpace(value)}
+		}
+		return nil
+	default:
+		var hops []string
+		for _, hop := range strings.Split(strings.Join(header.Values("X-Forwarded-For"), ","), ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+		return hops
+	}
+}
+
+// splitQuoted splits s on sep, ignoring separators inside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseNode parses an address with an optional port, as found in RemoteAddr,
+// X-Forwarded-For and the Forwarded "for" parameter, e.g. "192.0.2.1",
+// "192.0.2.1:80", "2001:db8::1" or "[2001:db8::1]:80".
+func parseNode(node string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(node); err == nil {
+		return normalizeAddr(addr), true
+	}
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return normalizeAddr(addr), true
+}
+
+func normalizeAddr(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
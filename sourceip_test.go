@@ -0,0 +1,56 @@
+package retraced
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceIPFromRequest(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		header     ForwardingHeader
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"untrusted peer ignores headers", "", "198.51.100.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.1"}, "198.51.100.1"},
+		{"right-most untrusted", "", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.1, 198.51.100.2, 10.0.0.5"}, "198.51.100.2"},
+		{"all trusted returns left-most", "", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.7, 192.0.2.1"}, "10.0.0.7"},
+		{"forwarded", HeaderForwarded, "10.0.0.1:1234", map[string]string{
+			"Forwarded": `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`,
+		}, "2001:db8:cafe::17"},
+		{"forwarded obfuscated hop", HeaderForwarded, "10.0.0.1:1234", map[string]string{"Forwarded": `for=203.0.113.5, for=_hidden, for=10.0.0.9`}, "10.0.0.9"},
+		{"x-real-ip", HeaderXRealIP, "[2001:db8:ffff::1]:443", map[string]string{"X-Real-IP": "203.0.113.8"}, "203.0.113.8"},
+		{"ipv4-mapped peer", "", "[::ffff:198.51.100.3]:80", nil, "198.51.100.3"},
+		{"ipv4-mapped trusted peer", "", "[::ffff:10.1.1.1]:80", map[string]string{"X-Forwarded-For": "2001:DB8:0:0::1"}, "2001:db8::1"},
+		{"client forwarded behind x-forwarded-for proxy", "", "10.0.0.1:1234", map[string]string{
+			"Forwarded":       "for=6.6.6.6",
+			"X-Forwarded-For": "203.0.113.1",
+		}, "203.0.113.1"},
+		{"client x-forwarded-for behind x-real-ip proxy", HeaderXRealIP, "10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For": "6.6.6.6",
+			"X-Real-IP":       "203.0.113.8",
+		}, "203.0.113.8"},
+		{"client x-forwarded-for behind forwarded proxy", HeaderForwarded, "10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For": "6.6.6.6",
+		}, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			trusted.Header = test.header
+			assert.Equal(t, test.expected, SourceIPFromRequest(r, trusted))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package retraced
 
 import (
+	"context"
 	"io"
 	"sync"
 )
@@ -14,7 +15,12 @@ type Stream struct {
 }
 
 func (c *Client) NewStream(sq *StructuredQuery, mask *EventNodeMask) (*Stream, error) {
-	conn, err := c.Query(sq, mask, 1000)
+	return c.NewStreamContext(context.Background(), sq, mask)
+}
+
+// NewStreamContext is like NewStream, but every page request is made with ctx.
+func (c *Client) NewStreamContext(ctx context.Context, sq *StructuredQuery, mask *EventNodeMask) (*Stream, error) {
+	conn, err := c.QueryContext(ctx, sq, mask, 1000)
 	if err != nil {
 		return nil, err
 	}
@@ -28,16 +34,15 @@ func (c *Client) NewStream(sq *StructuredQuery, mask *EventNodeMask) (*Stream, e
 func (s *Stream) Read() (*EventNode, error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
-	if s.i == len(s.ec.CurrentResults()) {
-		if s.ec.HasNextPage() {
-			if err := s.ec.NextPage(); err != nil {
-				return nil, err
-			}
-			s.i = 0
-		}
-		if s.i == len(s.ec.CurrentResults()) {
+	// Pages may be empty when results are filtered, see ViewerClient.
+	for s.i == len(s.ec.CurrentResults()) {
+		if !s.ec.HasNextPage() {
 			return nil, io.EOF
 		}
+		if err := s.ec.NextPage(); err != nil {
+			return nil, err
+		}
+		s.i = 0
 	}
 	event := s.ec.CurrentResults()[s.i]
 	s.i++
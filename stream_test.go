@@ -23,3 +23,22 @@ func TestStreamFixPanic(t *testing.T) {
 	_, err := s.Read()
 	assert.Equal(t, io.EOF, err)
 }
+
+func TestStreamSkipsEmptyPages(t *testing.T) {
+	s := &Stream{
+		ec: &MockEventsPager{
+			Pages: [][]*EventNode{
+				{{ID: "a"}},
+				{},
+				{{ID: "b"}},
+			},
+		},
+	}
+	for _, id := range []string{"a", "b"} {
+		event, err := s.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, id, event.ID)
+	}
+	_, err := s.Read()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,140 @@
+package retraced
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricStatusTokenRefresh counts API requests retried with a refreshed token
+// after a 401 response, under MetricAPIRequest.
+const MetricStatusTokenRefresh = "token_refresh"
+
+// TokenProvider supplies the API token of every request. It must be safe for
+// concurrent access.
+type TokenProvider interface {
+	// Token returns the current token.
+	Token(ctx context.Context) (string, error)
+	// Refresh is called when the API rejected the rejected token with a 401,
+	// and returns the token to retry with. Returning rejected again stops the
+	// retry. Concurrent requests may be rejected at once, so providers should
+	// return their current token without reloading it if it differs from
+	// rejected.
+	Refresh(ctx context.Context, rejected string) (string, error)
+}
+
+// StaticToken is a TokenProvider for a token that never changes. It is used
+// for the token passed to New.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t StaticToken) Refresh(ctx context.Context, rejected string) (string, error) {
+	return string(t), nil
+}
+
+// TokenFunc is a TokenProvider that calls a function, for example to read the
+// token from a secrets manager, on every request and refresh. The function
+// should cache the token itself. rejected is empty for requests, and holds
+// the rejected token on refresh, when a cached token equal to it must be
+// reloaded.
+type TokenFunc func(ctx context.Context, rejected string) (string, error)
+
+func (f TokenFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx, "")
+}
+
+func (f TokenFunc) Refresh(ctx context.Context, rejected string) (string, error) {
+	return f(ctx, rejected)
+}
+
+// FileTokenProvider reads the token from a file, such as a mounted Kubernetes
+// secret, and reloads it when the file's modification time changes. Leading
+// and trailing whitespace is trimmed.
+type FileTokenProvider struct {
+	path     string
+	interval time.Duration
+
+	token   string
+	modTime time.Time
+	checked time.Time
+	mtx     sync.Mutex
+}
+
+// NewFileTokenProvider reads the token at path. The file is checked for
+// changes at most once per interval, default is one second, and on Refresh.
+func NewFileTokenProvider(path string, interval time.Duration) (*FileTokenProvider, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	p := &FileTokenProvider{path: path, interval: interval}
+	if err := p.load(time.Now(), true); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FileTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.checked) >= p.interval {
+		if err := p.load(now, false); err != nil {
+			return "", err
+		}
+	}
+	return p.token, nil
+}
+
+func (p *FileTokenProvider) Refresh(ctx context.Context, rejected string) (string, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.token != rejected {
+		return p.token, nil
+	}
+	if err := p.load(time.Now(), true); err != nil {
+		return "", err
+	}
+	return p.token, nil
+}
+
+// load reads the file if it changed or force is set. It must be called with
+// p.mtx held.
+func (p *FileTokenProvider) load(now time.Time, force bool) error {
+	p.checked = now
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("retraced: reading token: %w", err)
+	}
+	if !force && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+	contents, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("retraced: reading token: %w", err)
+	}
+	token := strings.TrimSpace(string(contents))
+	if token == "" {
+		return fmt.Errorf("retraced: reading token: %s is empty", p.path)
+	}
+	p.token, p.modTime = token, info.ModTime()
+	return nil
+}
+
+func (c *Client) tokenProvider() TokenProvider {
+	if c.TokenProvider != nil {
+		return c.TokenProvider
+	}
+	return StaticToken(c.token)
+}
+
+func tokenAuthorization(token string) string {
+	return fmt.Sprintf("Token token=%s", token)
+}
@@ -0,0 +1,86 @@
+package retraced
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTokenProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0600))
+	p, err := NewFileTokenProvider(path, time.Hour)
+	require.NoError(t, err)
+
+	token, err := p.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "old", token)
+
+	require.NoError(t, os.WriteFile(path, []byte("new"), 0600))
+	token, err = p.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "old", token, "not checked again within the interval")
+
+	token, err = p.Refresh(context.Background(), "old")
+	require.NoError(t, err)
+	assert.Equal(t, "new", token)
+	token, err = p.Refresh(context.Background(), "old")
+	require.NoError(t, err)
+	assert.Equal(t, "new", token, "a stale rejection returns the current token")
+
+	_, err = NewFileTokenProvider(filepath.Join(t.TempDir(), "missing"), 0)
+	assert.Error(t, err)
+}
+
+func TestTokenRotation(t *testing.T) {
+	valid := "new"
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth != "Token token="+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"abc"}`))
+	}))
+	defer server.Close()
+
+	cached, latest := "old", "new"
+	provider := TokenFunc(func(ctx context.Context, rejected string) (string, error) {
+		if rejected != "" && rejected == cached {
+			cached = latest
+		}
+		return cached, nil
+	})
+	client, err := New("p", "", WithEndpoint(server.URL), WithTokenProvider(provider), WithHashVerification(HashVerificationOff))
+	require.NoError(t, err)
+
+	record, err := client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", record.ID)
+	assert.Equal(t, []string{"Token token=old", "Token token=new"}, seen)
+
+	// A rejected token is only refreshed once.
+	valid = "newer"
+	seen = nil
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	status, ok := err.(*StatusError)
+	require.True(t, ok, "expected *StatusError, got %v", err)
+	assert.Equal(t, http.StatusUnauthorized, status.StatusCode)
+	assert.Len(t, seen, 1, "refresh returned the rejected token")
+
+	latest = "newer"
+	seen = nil
+	_, err = client.ReportEvent(&Event{Action: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Token token=new", "Token token=newer"}, seen)
+}
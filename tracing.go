@@ -0,0 +1,97 @@
+package retraced
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceIDMetadataKey is the Metadata key that holds the trace id of the
+// context an event was reported in.
+const TraceIDMetadataKey = "trace_id"
+
+// TraceContext is a W3C Trace Context, as carried by the traceparent header.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// IsValid reports whether the trace and span ids are set.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the hex encoded trace id.
+func (tc TraceContext) TraceIDString() string {
+	return hex.EncodeToString(tc.TraceID[:])
+}
+
+// String returns the traceparent header value.
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceParent parses a traceparent header value.
+func ParseTraceParent(traceparent string) (TraceContext, error) {
+	var tc TraceContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return tc, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return tc, fmt.Errorf("invalid traceparent %q: %v", traceparent, err)
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return tc, fmt.Errorf("invalid traceparent %q: %v", traceparent, err)
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return tc, fmt.Errorf("invalid traceparent %q: %v", traceparent, err)
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return tc, fmt.Errorf("invalid traceparent %q: zero id", traceparent)
+	}
+	return tc, nil
+}
+
+type traceContextKey struct{}
+
+// WithTraceContext returns a context carrying tc. API requests made with the
+// context send it in the traceparent header.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the TraceContext carried by ctx.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok && tc.IsValid()
+}
+
+// Tracer receives callbacks around ReportEvent, GetViewerToken and each
+// GraphQL page request. A Tracer that starts a span should return a context
+// carrying the span's TraceContext, so it is propagated to the Retraced API.
+type Tracer interface {
+	// StartSpan is called before operation, which then uses the returned context
+	StartSpan(ctx context.Context, operation string) context.Context
+	// EndSpan is called with the context returned by StartSpan and the
+	// operation's error
+	EndSpan(ctx context.Context, operation string, err error)
+}
+
+// trace starts a span for operation and returns the function that ends it.
+func (c *Client) trace(ctx context.Context, operation string) (context.Context, func(err error)) {
+	if c.Tracer == nil {
+		return ctx, func(error) {}
+	}
+	ctx = c.Tracer.StartSpan(ctx, operation)
+	return ctx, func(err error) {
+		c.Tracer.EndSpan(ctx, operation, err)
+	}
+}
@@ -0,0 +1,52 @@
+package retraced
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type childSpanTracer struct {
+	ended []string
+}
+
+func (t *childSpanTracer) StartSpan(ctx context.Context, operation string) context.Context {
+	tc, _ := TraceContextFromContext(ctx)
+	tc.SpanID = [8]byte{9, 9, 9, 9, 9, 9, 9, 9}
+	return WithTraceContext(ctx, tc)
+}
+
+func (t *childSpanTracer) EndSpan(ctx context.Context, operation string, err error) {
+	t.ended = append(t.ended, operation)
+}
+
+func TestTraceParentPropagation(t *testing.T) {
+	var traceparent string
+	var reported Event
+	client := newCreatedServer(t, "", func(r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		json.NewDecoder(r.Body).Decode(&reported)
+	})
+	tracer := &childSpanTracer{}
+	client.Tracer = tracer
+
+	tc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	_, err = client.ReportEventContext(WithTraceContext(context.Background(), tc), &Event{Action: "a.test"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-0909090909090909-01", traceparent)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", reported.Metadata[TraceIDMetadataKey])
+	assert.Equal(t, []string{MetricReportEvent}, tracer.ended)
+}
+
+func TestParseTraceParentInvalid(t *testing.T) {
+	for _, v := range []string{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "00-xyz-00f067aa0ba902b7-01"} {
+		_, err := ParseTraceParent(v)
+		assert.Error(t, err, v)
+	}
+}
@@ -0,0 +1,137 @@
+package retraced
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// MetricViewerSession is the operation of exchanging a viewer token for a
+// viewer session.
+const MetricViewerSession = "viewer_session"
+
+// ViewerClient searches a group's events through the Viewer API with a viewer
+// token, as the embedded log viewer does. It can be used to test what end
+// users see, or to build custom viewers.
+type ViewerClient struct {
+	// GroupID is the group the viewer token was minted for. When set, events
+	// of other groups are left out of results.
+	GroupID string
+
+	client *Client
+}
+
+type viewerSessionRequest struct {
+	Token string `json:"token"`
+}
+
+type viewerSessionResponse struct {
+	Token string `json:"token"`
+}
+
+// NewViewerClient exchanges viewerToken for a viewer session with the Retraced
+// API at endpoint. Viewer tokens are one-time use, so a new token is needed for
+// every ViewerClient. groupID is the group the token was minted for, see
+// ViewerClient.GroupID. opts configure the underlying Client, e.g. with
+// WithHTTPClient, WithTimeout, WithLogger, WithMetrics or WithTracer.
+func NewViewerClient(ctx context.Context, endpoint string, groupID string, viewerToken string, opts ...Option) (*ViewerClient, error) {
+	client, err := New("", "", append([]Option{WithEndpoint(endpoint)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	client.authorization = func(session string) string { return session }
+
+	session, err := client.viewerSession(ctx, viewerToken)
+	if err != nil {
+		return nil, err
+	}
+	client.TokenProvider = StaticToken(session)
+
+	return &ViewerClient{
+		GroupID: groupID,
+		client:  client,
+	}, nil
+}
+
+func (c *Client) viewerSession(ctx context.Context, viewerToken string) (session string, err error) {
+	start := time.Now()
+	ctx, endSpan := c.trace(ctx, MetricViewerSession)
+	defer func() {
+		endSpan(err)
+		c.observe(MetricViewerSession, start, err)
+	}()
+
+	encoded, err := json.Marshal(&viewerSessionRequest{Token: viewerToken})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(withSecretBodies(ctx), "POST", fmt.Sprintf("%s/viewer/v1/viewersession", c.endpoint()), bytes.NewReader(encoded))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode}
+	}
+
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var sessionResp viewerSessionResponse
+	if err := json.Unmarshal(contents, &sessionResp); err != nil {
+		return "", err
+	}
+	if sessionResp.Token == "" {
+		return "", fmt.Errorf("retraced: viewer session response from %s has no token", req.URL.String())
+	}
+	return sessionResp.Token, nil
+}
+
+// Query searches for the group's events using the Viewer API's GraphQL
+// endpoint. The mask always includes GroupID, to check the group scope.
+func (v *ViewerClient) Query(sq *StructuredQuery, mask *EventNodeMask, pageSize int) (EventsPager, error) {
+	return v.QueryContext(context.Background(), sq, mask, pageSize)
+}
+
+// QueryContext is like Query, but every page request is made with ctx.
+func (v *ViewerClient) QueryContext(ctx context.Context, sq *StructuredQuery, mask *EventNodeMask, pageSize int) (EventsPager, error) {
+	scoped := EventNodeMask{}
+	if mask != nil {
+		scoped = *mask
+	}
+	scoped.GroupID = true
+
+	url := fmt.Sprintf("%s/viewer/v1/graphql", v.client.endpoint())
+	return v.client.query(ctx, url, sq, &scoped, pageSize, v.inScope)
+}
+
+// NewStream streams the group's events, see Query.
+func (v *ViewerClient) NewStream(sq *StructuredQuery, mask *EventNodeMask) (*Stream, error) {
+	return v.NewStreamContext(context.Background(), sq, mask)
+}
+
+// NewStreamContext is like NewStream, but every page request is made with ctx.
+func (v *ViewerClient) NewStreamContext(ctx context.Context, sq *StructuredQuery, mask *EventNodeMask) (*Stream, error) {
+	conn, err := v.QueryContext(ctx, sq, mask, 1000)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{
+		ec: conn,
+	}, nil
+}
+
+func (v *ViewerClient) inScope(event *EventNode) bool {
+	return v.GroupID == "" || (event.Group != nil && event.Group.ID == v.GroupID)
+}
@@ -0,0 +1,64 @@
+package retraced
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewerClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/viewer/v1/viewersession":
+			body := &viewerSessionRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(body))
+			if body.Token != "viewer-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Empty(t, r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":"session"}`))
+		case "/viewer/v1/graphql":
+			if r.Header.Get("Authorization") != "session" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body := &graphQLSearchBody{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(body))
+			assert.Contains(t, body.Query, "group")
+			root := &graphQLSearchRoot{}
+			root.Data.Search.TotalCount = 2
+			root.Data.Search.Edges = []*EventEdge{
+				{Cursor: "1", Node: &EventNode{ID: "a", Group: &Group{ID: "g1"}}},
+				{Cursor: "2", Node: &EventNode{ID: "b", Group: &Group{ID: "g2"}}},
+			}
+			json.NewEncoder(w).Encode(root)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	_, err := NewViewerClient(context.Background(), server.URL, "g1", "used-token")
+	status, ok := err.(*StatusError)
+	require.True(t, ok, "expected *StatusError, got %v", err)
+	assert.Equal(t, http.StatusUnauthorized, status.StatusCode)
+
+	viewer, err := NewViewerClient(context.Background(), server.URL, "g1", "viewer-token")
+	require.NoError(t, err)
+	stream, err := viewer.NewStream(&StructuredQuery{}, &EventNodeMask{ID: true})
+	require.NoError(t, err)
+
+	event, err := stream.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "a", event.ID)
+	_, err = stream.Read()
+	assert.Equal(t, io.EOF, err, "events of other groups are left out")
+}
@@ -0,0 +1,232 @@
+package retraced
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNotAuthenticated can be returned by ViewerTokenHandler.Authorize to
+// respond with 401 Unauthorized. Other errors respond with 403 Forbidden.
+var ErrNotAuthenticated = errors.New("retraced: request is not authenticated")
+
+const (
+	defaultCSRFCookieName = "retraced_csrf"
+	defaultCSRFHeaderName = "X-CSRF-Token"
+)
+
+// ViewerTokenHandler serves viewer tokens for the embedded Retraced log viewer.
+// It authorizes the request, mints a token for the returned group and actor,
+// and responds with JSON such as {"token": "...", "host": "..."}.
+//
+// Requests must pass a CSRF check, unless DisableCSRF is set: the CSRF header
+// must match the CSRF cookie, see IssueCSRFCookie. Requests with an Origin or
+// Referer header must also come from an allowed origin.
+type ViewerTokenHandler struct {
+	// Client mints the viewer tokens
+	Client *Client
+
+	// Authorize authenticates the request and returns the group whose audit
+	// log the actor may view. Return ErrNotAuthenticated for anonymous
+	// requests, or another error to forbid access.
+	Authorize func(r *http.Request) (groupID string, actorID string, isAdmin bool, err error)
+
+	// Cache, when set, hands out pre-minted tokens, and the unused tokens of
+	// an actor are discarded on logout
+	Cache *ViewerTokenCache
+
+	// ViewLogAction overrides the client's ViewLogAction for tokens minted here
+	ViewLogAction string
+
+	// ViewerHost, when set, is returned with the token as "host", the
+	// viewer API url the log viewer should use
+	ViewerHost string
+
+	// AllowedOrigins lists the origins, such as "https://app.example.com",
+	// that may request tokens. The default only allows the request's own host.
+	AllowedOrigins []string
+
+	// DisableCSRF turns off the CSRF check, e.g. for non-browser clients
+	DisableCSRF bool
+	// CSRFCookieName is the name of the CSRF cookie, default is "retraced_csrf"
+	CSRFCookieName string
+	// CSRFHeaderName is the name of the CSRF header, default is "X-CSRF-Token"
+	CSRFHeaderName string
+
+	// Logger receives errors minting and revoking tokens, default is the
+	// Logger of the Client
+	Logger Logger
+}
+
+type viewerTokenResponse struct {
+	Token string `json:"token"`
+	Host  string `json:"host,omitempty"`
+}
+
+// ServeHTTP serves a viewer token on GET and POST requests.
+func (h *ViewerTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	vtr, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var token *ViewerToken
+	var err error
+	if h.Cache != nil {
+		token, err = h.Cache.Get(r.Context(), vtr)
+	} else {
+		token, err = h.Client.GetViewerTokenContext(r.Context(), vtr)
+	}
+	if err != nil {
+		h.logger().ErrorContext(r.Context(), "retraced: failed to mint viewer token", "group_id", vtr.GroupID, "actor_id", vtr.ActorID, "error", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(&viewerTokenResponse{Token: token.Token, Host: h.ViewerHost})
+}
+
+// LogoutHandler returns a handler that deletes the viewer sessions of the
+// authorized actor with DeleteViewerSessions, for POST requests on logout. It
+// applies the same CSRF and origin checks as ServeHTTP, and responds with 204
+// No Content.
+func (h *ViewerTokenHandler) LogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		vtr, ok := h.authorize(w, r)
+		if !ok {
+			return
+		}
+		if h.Cache != nil {
+			h.Cache.InvalidateActor(vtr.GroupID, vtr.ActorID)
+		}
+		if err := h.Client.DeleteViewerSessions(vtr.GroupID, vtr.ActorID); err != nil {
+			h.logger().ErrorContext(r.Context(), "retraced: failed to delete viewer sessions", "group_id", vtr.GroupID, "actor_id", vtr.ActorID, "error", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// IssueCSRFCookie sets a new random CSRF cookie and returns its value, which
+// the page must send back in the CSRF header.
+func (h *ViewerTokenHandler) IssueCSRFCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.csrfCookieName(),
+		Value:    token,
+		Path:     "/",
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// authorize checks the origin and CSRF token of r and calls Authorize. It
+// writes the error response and returns false if the request is rejected.
+func (h *ViewerTokenHandler) authorize(w http.ResponseWriter, r *http.Request) (*ViewerTokenRequest, bool) {
+	if !h.allowedOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, false
+	}
+	if !h.DisableCSRF && !h.validCSRF(r) {
+		http.Error(w, "invalid csrf token", http.StatusForbidden)
+		return nil, false
+	}
+
+	groupID, actorID, isAdmin, err := h.Authorize(r)
+	if errors.Is(err, ErrNotAuthenticated) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, false
+	}
+	return &ViewerTokenRequest{
+		GroupID:       groupID,
+		ActorID:       actorID,
+		IsAdmin:       isAdmin,
+		ViewLogAction: h.ViewLogAction,
+	}, true
+}
+
+// allowedOrigin reports whether the Origin header, or the origin of the
+// Referer header if there is none, is allowed. Requests with neither header
+// are not made by a browser on another site, and are allowed.
+func (h *ViewerTokenHandler) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	if len(h.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range h.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *ViewerTokenHandler) validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(h.csrfCookieName())
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(h.csrfHeaderName())
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+func (h *ViewerTokenHandler) csrfCookieName() string {
+	if h.CSRFCookieName != "" {
+		return h.CSRFCookieName
+	}
+	return defaultCSRFCookieName
+}
+
+func (h *ViewerTokenHandler) csrfHeaderName() string {
+	if h.CSRFHeaderName != "" {
+		return h.CSRFHeaderName
+	}
+	return defaultCSRFHeaderName
+}
+
+func (h *ViewerTokenHandler) logger() Logger {
+	if h.Logger != nil {
+		return h.Logger
+	}
+	return h.Client.logger()
+}
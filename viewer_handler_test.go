@@ -0,0 +1,81 @@
+package retraced
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewerTokenHandler(t *testing.T) {
+	var deleted string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = r.URL.Path
+			return
+		}
+		assert.Equal(t, "g1", r.URL.Query().Get("group_id"))
+		assert.Equal(t, "u1", r.URL.Query().Get("actor_id"))
+		w.Write([]byte(`{"token":"abc"}`))
+	}))
+	defer api.Close()
+	client, err := New("p", "t", WithEndpoint(api.URL))
+	require.NoError(t, err)
+
+	h := &ViewerTokenHandler{
+		Client: client,
+		Authorize: func(r *http.Request) (string, string, bool, error) {
+			switch r.Header.Get("X-User") {
+			case "":
+				return "", "", false, ErrNotAuthenticated
+			case "u1":
+				return "g1", "u1", false, nil
+			}
+			return "", "", false, errors.New("not a member")
+		},
+		ViewerHost:     "https://retraced.example.com/viewer/v1",
+		AllowedOrigins: []string{"https://app.example.com"},
+	}
+
+	csrf := httptest.NewRecorder()
+	csrfToken, err := h.IssueCSRFCookie(csrf, httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	cookie := csrf.Result().Cookies()[0]
+
+	request := func(method, target, user, origin string, withCSRF bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("X-User", user)
+		r.Header.Set("Origin", origin)
+		if withCSRF {
+			r.AddCookie(cookie)
+			r.Header.Set("X-CSRF-Token", csrfToken)
+		}
+		w := httptest.NewRecorder()
+		if target == "/logout" {
+			h.LogoutHandler().ServeHTTP(w, r)
+		} else {
+			h.ServeHTTP(w, r)
+		}
+		return w
+	}
+
+	w := request("POST", "/token", "u1", "https://app.example.com", true)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, map[string]string{"token": "abc", "host": "https://retraced.example.com/viewer/v1"}, body)
+
+	assert.Equal(t, http.StatusForbidden, request("POST", "/token", "u1", "https://evil.example.com", true).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/token", "u1", "https://app.example.com", false).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/token", "", "https://app.example.com", true).Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/token", "u2", "https://app.example.com", true).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request("DELETE", "/token", "u1", "https://app.example.com", true).Code)
+
+	assert.Equal(t, http.StatusNoContent, request("POST", "/logout", "u1", "https://app.example.com", true).Code)
+	assert.Equal(t, "/v1/project/p/group/g1/actor/u1/viewersessions", deleted)
+}
@@ -1,5 +1,171 @@
 package retraced
 
+import (
+	"context"
+	"sync"
+	"time"
+)
+
 type ViewerToken struct {
 	Token string `json:"token"`
 }
+
+// ViewerTokenRequest is the scope of a viewer token. The Retraced API does not
+// take an expiry for viewer tokens, see ViewerTokenCache for a local one.
+type ViewerTokenRequest struct {
+	// GroupID is the group whose audit log can be viewed
+	GroupID string
+	// ActorID is the actor viewing the audit log
+	ActorID string
+	// IsAdmin allows the viewer to see all events of the group
+	IsAdmin bool
+	// TargetID, when set, limits the viewer to events of one target
+	TargetID string
+	// ViewLogAction overrides the client's ViewLogAction for this token
+	ViewLogAction string
+}
+
+// ViewerTokenCache keeps a small pool of minted viewer tokens for each
+// ViewerTokenRequest, so that a page load does not wait for a token to be
+// minted, and lets tokens be revoked by actor on logout.
+//
+// A viewer token can only be exchanged for a viewer session once, so Get hands
+// out each token at most once and then refills the pool of its scope in the
+// background. Tokens that were not handed out within the ttl are discarded.
+// It is safe for concurrent access.
+type ViewerTokenCache struct {
+	// PoolSize is the number of tokens kept minted per scope, default is 1
+	PoolSize int
+
+	client *Client
+	ttl    time.Duration
+	pools  map[ViewerTokenRequest]*viewerTokenPool
+	mtx    sync.Mutex
+}
+
+// viewerTokenPool holds the unused tokens of a scope, oldest first.
+type viewerTokenPool struct {
+	tokens    []viewerTokenEntry
+	refilling bool
+}
+
+type viewerTokenEntry struct {
+	token   *ViewerToken
+	expires time.Time
+}
+
+// NewViewerTokenCache creates a cache of tokens minted by client that are
+// handed out for at most ttl after they are minted, default is 30 seconds.
+func NewViewerTokenCache(client *Client, ttl time.Duration) *ViewerTokenCache {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &ViewerTokenCache{
+		client: client,
+		ttl:    ttl,
+		pools:  map[ViewerTokenRequest]*viewerTokenPool{},
+	}
+}
+
+// Get removes an unexpired token for vtr from the pool and returns it, or
+// mints a new one if there is none, so no other caller receives the same
+// token. Either way the pool is refilled in the background.
+func (c *ViewerTokenCache) Get(ctx context.Context, vtr *ViewerTokenRequest) (*ViewerToken, error) {
+	key := *vtr
+	now := time.Now()
+
+	c.mtx.Lock()
+	c.sweep(now)
+	pool, ok := c.pools[key]
+	if !ok {
+		pool = &viewerTokenPool{}
+		c.pools[key] = pool
+	}
+	var token *ViewerToken
+	if len(pool.tokens) > 0 {
+		token = pool.tokens[0].token
+		pool.tokens = pool.tokens[1:]
+	}
+	c.startRefill(key, pool)
+	c.mtx.Unlock()
+
+	if token != nil {
+		return token, nil
+	}
+	return c.client.GetViewerTokenContext(ctx, &key)
+}
+
+// Invalidate discards the unused tokens for vtr.
+func (c *ViewerTokenCache) Invalidate(vtr *ViewerTokenRequest) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.pools, *vtr)
+}
+
+// InvalidateActor discards the unused tokens of an actor in a group, e.g. on
+// logout.
+func (c *ViewerTokenCache) InvalidateActor(groupID, actorID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for key := range c.pools {
+		if key.GroupID == groupID && key.ActorID == actorID {
+			delete(c.pools, key)
+		}
+	}
+}
+
+func (c *ViewerTokenCache) poolSize() int {
+	if c.PoolSize <= 0 {
+		return 1
+	}
+	return c.PoolSize
+}
+
+// startRefill mints tokens for pool in the background unless it is full or
+// already being refilled. It must be called with c.mtx held.
+func (c *ViewerTokenCache) startRefill(key ViewerTokenRequest, pool *viewerTokenPool) {
+	if pool.refilling || len(pool.tokens) >= c.poolSize() {
+		return
+	}
+	pool.refilling = true
+	go c.refill(key, pool)
+}
+
+// refill mints tokens until pool is full. Tokens minted for a pool that was
+// invalidated meanwhile are dropped.
+func (c *ViewerTokenCache) refill(key ViewerTokenRequest, pool *viewerTokenPool) {
+	ctx := context.Background()
+	for {
+		token, err := c.client.GetViewerTokenContext(ctx, &key)
+
+		c.mtx.Lock()
+		if err != nil || c.pools[key] != pool {
+			pool.refilling = false
+			c.mtx.Unlock()
+			if err != nil {
+				c.client.logger().WarnContext(ctx, "retraced: refilling viewer tokens", "group_id", key.GroupID, "error", err)
+			}
+			return
+		}
+		pool.tokens = append(pool.tokens, viewerTokenEntry{token: token, expires: time.Now().Add(c.ttl)})
+		if len(pool.tokens) >= c.poolSize() {
+			pool.refilling = false
+			c.mtx.Unlock()
+			return
+		}
+		c.mtx.Unlock()
+	}
+}
+
+// sweep discards expired tokens, and pools that are empty and not being
+// refilled. It must be called with c.mtx held.
+func (c *ViewerTokenCache) sweep(now time.Time) {
+	for key, pool := range c.pools {
+		for len(pool.tokens) > 0 && !now.Before(pool.tokens[0].expires) {
+			pool.tokens = pool.tokens[1:]
+		}
+		if len(pool.tokens) == 0 && !pool.refilling {
+			delete(c.pools, key)
+		}
+	}
+}
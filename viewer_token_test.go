@@ -0,0 +1,105 @@
+package retraced
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func viewerTokenServer(t *testing.T, minted *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(minted, 1)
+		assert.Equal(t, "/publisher/v1/project/p/viewertoken", r.URL.Path)
+		assert.Equal(t, "g1", r.URL.Query().Get("group_id"))
+		assert.Equal(t, "audit.log.view.target", r.URL.Query().Get("view_log_action"))
+		fmt.Fprintf(w, `{"token":"token-%d"}`, n)
+	}))
+}
+
+func TestViewerTokenCache(t *testing.T) {
+	var minted int32
+	server := viewerTokenServer(t, &minted)
+	defer server.Close()
+	client, err := New("p", "t", WithEndpoint(server.URL), WithViewLogAction("audit.log.view"))
+	require.NoError(t, err)
+
+	cache := NewViewerTokenCache(client, time.Minute)
+	vtr := &ViewerTokenRequest{GroupID: "g1", ActorID: "u1", TargetID: "t1", ViewLogAction: "audit.log.view.target"}
+	refilled := func(n int32) {
+		assert.Eventually(t, func() bool {
+			cache.mtx.Lock()
+			defer cache.mtx.Unlock()
+			return atomic.LoadInt32(&minted) == n && !cache.pools[*vtr].refilling
+		}, time.Second, time.Millisecond)
+	}
+
+	// The first token is minted on demand, and the next one in the
+	// background.
+	first, err := cache.Get(context.Background(), vtr)
+	require.NoError(t, err)
+	refilled(2)
+	second, err := cache.Get(context.Background(), vtr)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Token, second.Token)
+	refilled(3)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := cache.Get(context.Background(), vtr)
+			assert.NoError(t, err)
+			tokens[i] = token.Token
+		}(i)
+	}
+	wg.Wait()
+	seen := map[string]bool{first.Token: true, second.Token: true}
+	for _, token := range tokens {
+		assert.False(t, seen[token], "token %s handed out twice", token)
+		seen[token] = true
+	}
+	assert.Len(t, seen, 7)
+	assert.True(t, seen["token-3"], "the pre-minted token is handed out")
+
+	// Tokens minted before logout are not handed out.
+	n := atomic.LoadInt32(&minted)
+	refilled(n)
+	cache.InvalidateActor("g1", "u1")
+	token, err := cache.Get(context.Background(), vtr)
+	require.NoError(t, err)
+	var number int32
+	_, err = fmt.Sscanf(token.Token, "token-%d", &number)
+	require.NoError(t, err)
+	assert.Greater(t, number, n)
+}
+
+func TestViewerTokenCacheExpiry(t *testing.T) {
+	var minted int32
+	server := viewerTokenServer(t, &minted)
+	defer server.Close()
+	client, err := New("p", "t", WithEndpoint(server.URL))
+	require.NoError(t, err)
+
+	cache := NewViewerTokenCache(client, time.Millisecond)
+	vtr := &ViewerTokenRequest{GroupID: "g1", ActorID: "u1", ViewLogAction: "audit.log.view.target"}
+	_, err = cache.Get(context.Background(), vtr)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&minted) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	token, err := cache.Get(context.Background(), vtr)
+	require.NoError(t, err)
+	var number int
+	_, err = fmt.Sscanf(token.Token, "token-%d", &number)
+	require.NoError(t, err)
+	assert.Greater(t, number, 2, "an expired token is not handed out")
+}